@@ -2,22 +2,82 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/jenpet/traebeler/internal"
 	"github.com/jenpet/traebeler/internal/log"
 	"os"
 	"os/signal"
+	"syscall"
 )
 
+const usage = `Usage: traebeler [command]
+
+Commands:
+  run              continuously publishes the domains of traefik (default)
+  sync             publishes the domains of traefik once and exits non-zero on failure,
+                   3 in case the deletion guard refused to remove domains
+  plan             prints the changes a sync would apply without applying them
+  domains          prints the domains of traefik together with their routers
+  config validate  checks the configuration without contacting anything
+  help             prints this help
+`
+
 func main() {
+	args := os.Args[1:]
+	command := "run"
+	if len(args) > 0 {
+		command = args[0]
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	listenCancel(cancel)
-	internal.Do(ctx)
+	switch command {
+	case "run":
+		internal.Do(ctx)
+	case "sync":
+		exit(internal.Sync(ctx))
+	case "plan":
+		exit(internal.Plan(ctx, os.Stdout))
+	case "domains":
+		exit(internal.Domains(ctx, os.Stdout))
+	case "config":
+		if len(args) != 2 || args[1] != "validate" {
+			fail("unknown config command, expected 'config validate'")
+		}
+		err := internal.ValidateConfig()
+		if err == nil {
+			fmt.Println("Configuration is valid.")
+		}
+		exit(err)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fail(fmt.Sprintf("unknown command '%s'", command))
+	}
+}
+
+// exit terminates traebeler with a non-zero exit code in case of an error. A removal refused by the deletion guard
+// exits with 3 to tell it apart from failures.
+func exit(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, internal.ErrRemovalRefused) {
+			os.Exit(3)
+		}
+		os.Exit(1)
+	}
+}
+
+// fail prints the usage and terminates traebeler due to invalid arguments.
+func fail(msg string) {
+	fmt.Fprintf(os.Stderr, "%s\n\n%s", msg, usage)
+	os.Exit(2)
 }
 
-// listenCancel handles a graceful shutdown in case the os receives a cancel signal
+// listenCancel handles a graceful shutdown in case the os receives an interrupt or terminate signal
 func listenCancel(cancel context.CancelFunc) {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		osSignal := <-c
 		log.Printf("Received os signal '%+v'", osSignal)
@@ -5,6 +5,7 @@ go 1.13
 require (
 	github.com/bobesa/go-domain-util v0.0.0-20190911083921-4033b5f7dd89
 	github.com/kelseyhightower/envconfig v1.4.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/sirupsen/logrus v1.7.0
 	github.com/stretchr/testify v1.7.0
 	github.com/traefik/traefik/v2 v2.5.0
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/jenpet/traebeler/internal/log"
+	persistence "github.com/jenpet/traebeler/internal/state"
+	"reflect"
+	"time"
+)
+
+// baselineVersion is the version of the persisted baseline's schema. It has to be increased on incompatible changes.
+const baselineVersion = 1
+
+// baseline holds the last domains handed to the processors and survives restarts. It becomes the previous state of
+// the first cycle after a start, hence the deletion guard protects that cycle as well.
+type baseline struct {
+	Version int             `json:"version"`
+	SavedAt time.Time       `json:"savedAt"`
+	Domains []domain.Domain `json:"domains"`
+}
+
+// newBaselineStore returns the store of the baseline configured by the TRAEBELER_STATE_* environment variables, nil in
+// case persistence is disabled.
+func newBaselineStore() (stateStore, error) {
+	store, err := persistence.NewStore("domains")
+	if err != nil || store == nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// restoreBaseline seeds the previous domains of the worker with the baseline of the store and keeps the store to
+// update the baseline after every cycle. During dry runs the baseline is only read, since planned changes are never
+// applied. A baseline which can't be read is discarded, the first cycle is not protected by the deletion guard then.
+func (w *worker) restoreBaseline(store stateStore, dryRun bool) {
+	if store == nil {
+		return
+	}
+	if !dryRun {
+		w.store = store
+	}
+	var b baseline
+	ok, err := store.Load(&b)
+	switch {
+	case err != nil:
+		log.Errorf("Failed loading the domains of the last run, the deletion guard does not protect the first cycle. Error: %v", err)
+	case !ok:
+		log.Info("No domains of a previous run were saved yet.")
+	case b.Version != baselineVersion:
+		log.Errorf("Discarding the domains of the last run with unsupported version %d, expected version %d.", b.Version, baselineVersion)
+	default:
+		w.previous.domains = b.Domains
+		log.Infof("Restored %d domains of the last run saved at %s as baseline of the deletion guard.", len(b.Domains), b.SavedAt.Format(time.RFC3339))
+	}
+}
+
+// saveBaseline persists the domains of the current cycle in case they differ from the previous ones.
+func (w *worker) saveBaseline(current []domain.Domain) {
+	if w.store == nil || reflect.DeepEqual(w.previous.domains, current) {
+		return
+	}
+	if err := w.store.Save(baseline{Version: baselineVersion, SavedAt: time.Now(), Domains: current}); err != nil {
+		log.Errorf("Failed saving the domains of this cycle. Error: %v", err)
+	}
+}
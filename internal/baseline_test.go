@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProcessDomains_withBaseline_shouldGuardFirstCycleAfterRestart(t *testing.T) {
+	store := &memoryStore{}
+	w := worker{provider: staticProvider{"foo.bar", "sub.foo.bar"}, guard: &deletionGuard{maxPercent: 50}}
+	w.restoreBaseline(store, false)
+	_, _, err := w.poll(context.Background())
+	assert.Nil(t, err)
+
+	restarted := worker{provider: staticProvider{}, guard: &deletionGuard{maxPercent: 50}}
+	restarted.restoreBaseline(store, false)
+	assert.Equal(t, []string{"foo.bar", "sub.foo.bar"}, domain.Names(restarted.previous.domains))
+	current, settled, err := restarted.poll(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, settled)
+	assert.Equal(t, []string{"foo.bar", "sub.foo.bar"}, domain.Names(current.domains), "removal of every domain should be refused")
+}
+
+func TestRestoreBaseline_whenDryRun_shouldNotSaveBaseline(t *testing.T) {
+	store := &memoryStore{}
+	w := worker{provider: staticProvider{"foo.bar"}}
+	w.restoreBaseline(store, true)
+	_, _, err := w.poll(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, store.data, "planned domains should not become the baseline")
+}
+
+func TestRestoreBaseline_whenVersionIsUnsupported_shouldDiscardIt(t *testing.T) {
+	store := &memoryStore{}
+	assert.Nil(t, store.Save(baseline{Version: baselineVersion + 1, Domains: toDomains("foo.bar")}))
+	w := worker{}
+	w.restoreBaseline(store, false)
+	assert.Empty(t, w.previous.domains)
+}
+
+// memoryStore keeps the JSON document of the saved state in memory.
+type memoryStore struct {
+	data []byte
+}
+
+func (ms *memoryStore) Load(v interface{}) (bool, error) {
+	if ms.data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(ms.data, v)
+}
+
+func (ms *memoryStore) Save(v interface{}) error {
+	data, err := json.Marshal(v)
+	ms.data = data
+	return err
+}
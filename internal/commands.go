@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jenpet/traebeler/internal/publicip"
+	"github.com/jenpet/traebeler/internal/target"
+	"github.com/jenpet/traebeler/internal/traefik"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Sync performs a single reconciliation. An error is returned in case the provider or any of the processors failed.
+// ErrRemovalRefused is returned in case the deletion guard held back the removal of domains.
+func Sync(ctx context.Context) error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	_, err = reconcile(ctx, cfg)
+	return err
+}
+
+// Plan performs a single reconciliation in dry run mode and writes the changes every processor would apply to out.
+func Plan(ctx context.Context, out io.Writer) error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	cfg.DryRun = true
+	processors, err := reconcile(ctx, cfg)
+	for _, p := range processors {
+		steps := p.(dryRunProcessor).Plan()
+		fmt.Fprintf(out, "Processor '%s' plans %d changes:\n", p.ID(), len(steps))
+		for _, step := range steps {
+			fmt.Fprintf(out, "  %s\n", step)
+		}
+	}
+	return err
+}
+
+// reconcile initializes the processors and hands them the domains of a single poll. Flap protection does not apply since
+// there is no previous poll, the deletion guard only applies in case the domains of the last run are persisted. A
+// refused removal is returned as ErrRemovalRefused, wrapped together with the errors of failed processors. The
+// processors are returned as long as they could be initialized.
+func reconcile(ctx context.Context, cfg config) ([]processor, error) {
+	processors, err := initProcessors(cfg)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := traefik.NewProvider()
+	if err != nil {
+		return nil, err
+	}
+	detector, err := publicip.NewDetector()
+	if err != nil {
+		return nil, err
+	}
+	store, err := newBaselineStore()
+	if err != nil {
+		return processors, err
+	}
+	timeout := time.Second * time.Duration(cfg.CycleTimeout)
+	w := &worker{provider: provider, addresses: detector, timeout: timeout, guard: newDeletionGuard(cfg)}
+	w.restoreBaseline(store, cfg.DryRun)
+	current, _, err := w.poll(ctx)
+	if err != nil {
+		return processors, err
+	}
+	var errs []string
+	for _, p := range processors {
+		if err := newRunner(p, timeout).process(ctx, current); err != nil {
+			errs = append(errs, fmt.Sprintf("processor '%s': %v", p.ID(), err))
+		}
+	}
+	switch {
+	case w.guard.refused() && len(errs) > 0:
+		return processors, fmt.Errorf("%s; %w", strings.Join(errs, "; "), ErrRemovalRefused)
+	case w.guard.refused():
+		return processors, ErrRemovalRefused
+	case len(errs) > 0:
+		return processors, errors.New(strings.Join(errs, "; "))
+	}
+	return processors, nil
+}
+
+// Domains writes the domains reported by traefik together with their routers and entrypoints to out.
+func Domains(ctx context.Context, out io.Writer) error {
+	provider, err := traefik.NewProvider()
+	if err != nil {
+		return err
+	}
+	domains, err := provider.GetDomains(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tROUTERS\tENTRYPOINTS")
+	for _, d := range domains {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", d.Name, strings.Join(d.RouterNames(), ","), strings.Join(d.EntryPoints, ","))
+	}
+	return tw.Flush()
+}
+
+// ValidateConfig checks the configuration of traebeler, traefik, the public IP detection, the target addresses and
+// all configured processors without contacting any of them. All invalid parts are reported at once.
+func ValidateConfig() error {
+	var errs []string
+	cfg, err := readConfig()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if _, err := traefik.NewProvider(); err != nil {
+		errs = append(errs, fmt.Sprintf("traefik: %v", err))
+	}
+	if _, err := publicip.NewDetector(); err != nil {
+		errs = append(errs, fmt.Sprintf("public IP detection: %v", err))
+	}
+	if _, err := target.NewResolver(); err != nil {
+		errs = append(errs, fmt.Sprintf("target addresses: %v", err))
+	}
+	if _, err := newBaselineStore(); err != nil {
+		errs = append(errs, fmt.Sprintf("state: %v", err))
+	}
+	if _, err := initProcessors(cfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/jenpet/traebeler/internal/test"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestDomains_shouldPrintDomainsWithTheirRouters(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://traefik.io").
+		Get("/api/http/routers").
+		Reply(http.StatusOK).
+		File("traefik/testdata/v3_http_routers_response.json")
+
+	var out bytes.Buffer
+	assert.Nil(t, Domains(context.Background(), &out))
+	assert.Contains(t, out.String(), "DOMAIN")
+	assert.Regexp(t, `whoami\.foo\.bar\s+whoami@docker\s+websecure\n`, out.String())
+}
+
+func TestSync_whenTraefikFails_shouldReturnError(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://traefik.io").
+		Get("/api/http/routers").
+		Reply(http.StatusInternalServerError)
+
+	assert.NotNil(t, Sync(context.Background()), "a failed sync should be reported")
+}
+
+func TestSync_whenDeletionGuardRefusesRemoval_shouldReturnRefusal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traebeler-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer test.ClearEnvs(test.SetEnvs(map[string]string{"TRAEBELER_STATE_BACKEND": "file", "TRAEBELER_STATE_DIR": dir}))
+	store, err := newBaselineStore()
+	assert.Nil(t, err)
+	assert.Nil(t, store.Save(baseline{Version: baselineVersion, Domains: toDomains("foo.bar", "a.foo.bar", "b.foo.bar", "c.foo.bar")}))
+
+	defer gock.Off()
+	gock.New("http://traefik.io").
+		Get("/api/http/routers").
+		Reply(http.StatusOK).
+		File("test/data/traefik/http_routers_response.json")
+
+	err = Sync(context.Background())
+	assert.True(t, errors.Is(err, ErrRemovalRefused), "the held back removal should be reported")
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.Nil(t, ValidateConfig(), "the integration test configuration should be valid")
+
+	defer test.ClearEnvs(test.SetEnvs(map[string]string{
+		"TRAEBELER_LOOKUP_INTERVAL":            "0",
+		"TRAEFIK_INCLUDE_ROUTERS":              "/[/",
+		"TRAEBELER_TARGET_IPV4":                "foo",
+		"TRAEBELER_PROCESSOR_FROXLOR_REGISTRY": "foo",
+	}))
+	err := ValidateConfig()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid worker config")
+	assert.Contains(t, err.Error(), "traefik")
+	assert.Contains(t, err.Error(), "target addresses")
+	assert.Contains(t, err.Error(), "unknown registry")
+}
@@ -0,0 +1,98 @@
+// Package domain describes the domains which are handed from providers to processors.
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// Domain is a hostname served by traefik together with the routers it originates from. Processors can use the
+// additional context, e.g. to point domains of different entrypoints to different addresses.
+type Domain struct {
+	Name string
+	// EntryPoints holds the entrypoints of all routers serving the domain in the order they were reported
+	EntryPoints []string
+	// Routers holds every router serving the domain in the order they were reported
+	Routers []Router
+	// Metadata holds free-form information a provider attaches to the domain, e.g. the service and priority of the
+	// traefik router it originates from
+	Metadata map[string]string
+}
+
+// Router is the source of a domain.
+type Router struct {
+	Name string
+	// Provider is the namespace the router is defined in, e.g. "docker" or "file"
+	Provider string
+	// Protocol is either "http" or "tcp"
+	Protocol    string
+	Service     string
+	EntryPoints []string
+	// TLS is nil in case the router does not handle TLS
+	TLS *TLS
+}
+
+// TLS describes how a router handles TLS.
+type TLS struct {
+	CertResolver string
+	// Passthrough is true for TCP routers forwarding TLS connections without terminating them
+	Passthrough bool
+}
+
+// RouterNames returns the names of all routers serving the domain.
+func (d Domain) RouterNames() []string {
+	names := make([]string, 0, len(d.Routers))
+	for _, r := range d.Routers {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+// String returns the name of the domain followed by its routers, e.g. "foo.bar (whoami@docker)".
+func (d Domain) String() string {
+	if len(d.Routers) == 0 {
+		return d.Name
+	}
+	return d.Name + " (" + strings.Join(d.RouterNames(), ", ") + ")"
+}
+
+// Names returns the names of the given domains.
+func Names(domains []Domain) []string {
+	names := make([]string, 0, len(domains))
+	for _, d := range domains {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+// ChangeSet describes how the domains changed since they were processed the last time. Domains whose descriptor
+// changed, e.g. since they are served on another entrypoint now, are reported as added.
+type ChangeSet struct {
+	Added     []Domain
+	Removed   []Domain
+	Unchanged []Domain
+	// Domains holds the full list of current domains in the order they were reported by the provider
+	Domains []Domain
+	// IPv4 and IPv6 are the detected public addresses, empty in case they are not watched or could not be detected
+	IPv4, IPv6 string
+	// IPChanged is true in case any of the public addresses changed
+	IPChanged bool
+}
+
+// Empty returns true in case neither the domains nor the public addresses changed.
+func (cs ChangeSet) Empty() bool {
+	return len(cs.Added) == 0 && len(cs.Removed) == 0 && !cs.IPChanged
+}
+
+// RecordFailure describes a record of a domain which a processor repeatedly failed to update.
+type RecordFailure struct {
+	// Record identifies the record, e.g. "sub.foo.bar (A)"
+	Record   string    `json:"record"`
+	Attempts int       `json:"attempts"`
+	LastErr  string    `json:"lastError"`
+	Since    time.Time `json:"since"`
+	// NextAttempt is the earliest time the record is updated again
+	NextAttempt time.Time `json:"nextAttempt"`
+	// Quarantined records kept failing and are only retried rarely
+	Quarantined bool `json:"quarantined"`
+}
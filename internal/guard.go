@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"errors"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/jenpet/traebeler/internal/log"
+	"github.com/jenpet/traebeler/internal/metrics"
+	"sync"
+)
+
+// ErrRemovalRefused is returned by a single reconciliation in case the deletion guard refused to remove domains, hence
+// the run was held back although the processors succeeded.
+var ErrRemovalRefused = errors.New("deletion guard refused to remove domains")
+
+// deletionGuard refuses to remove more domains at once than configured, e.g. since a misconfigured traefik or a
+// single bad response makes every domain vanish. The limits count domains, not the records a processor maintains
+// for them. The domains of a refused removal are kept, hence added and updated domains are still processed. A single
+// refused removal can be approved explicitly.
+type deletionGuard struct {
+	// maxPercent of the previous domains and maxCount domains may be removed at once, 0 disables the respective limit
+	maxPercent int
+	maxCount   int
+	// minPrevious is the number of previous domains below which the percentage limit does not apply, since removing a
+	// single domain of a small installation would exceed it already
+	minPrevious int
+
+	mu       sync.Mutex
+	approved bool
+	// refusals counts the consecutive refused removals
+	refusals int
+}
+
+func newDeletionGuard(cfg config) *deletionGuard {
+	return &deletionGuard{
+		maxPercent:  cfg.DeletionGuardPercent,
+		maxCount:    cfg.DeletionGuardCount,
+		minPrevious: cfg.DeletionGuardMinDomains,
+		approved:    cfg.DeletionGuardApprove,
+	}
+}
+
+// approve lets the next removal exceeding the limits through.
+func (g *deletionGuard) approve() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.approved = true
+	log.Info("Approved the next removal of domains exceeding the deletion guard limits.")
+}
+
+// apply returns the current domains in case the removal of the previous ones which are missing is within the limits
+// or approved. Otherwise the missing domains are appended to the current ones.
+func (g *deletionGuard) apply(previous, current []domain.Domain) []domain.Domain {
+	reported := map[string]bool{}
+	for _, d := range current {
+		reported[d.Name] = true
+	}
+	var removed []domain.Domain
+	for _, d := range previous {
+		if !reported[d.Name] {
+			removed = append(removed, d)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.exceeds(len(removed), len(previous)) {
+		g.refusals = 0
+		metrics.DeletionGuardPassed()
+		return current
+	}
+	if g.approved {
+		log.Infof("Removing %d of %d domains exceeding the deletion guard limits since it was approved.", len(removed), len(previous))
+		g.approved, g.refusals = false, 0
+		metrics.DeletionGuardPassed()
+		return current
+	}
+	g.refusals++
+	metrics.DeletionGuardRefused()
+	log.Errorf("DELETION GUARD: refusing to remove %d of %d domains (%v) for %d consecutive cycles, limits are %d%% and %d domains. "+
+		"Send SIGUSR1 to approve the removal once.", len(removed), len(previous), domain.Names(removed), g.refusals, g.maxPercent, g.maxCount)
+	return append(append([]domain.Domain{}, current...), removed...)
+}
+
+// refused returns true in case the last removal was refused.
+func (g *deletionGuard) refused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.refusals > 0
+}
+
+func (g *deletionGuard) exceeds(removed, previous int) bool {
+	if removed == 0 {
+		return false
+	}
+	if g.maxCount > 0 && removed > g.maxCount {
+		return true
+	}
+	return g.maxPercent > 0 && previous >= g.minPrevious && removed*100 > g.maxPercent*previous
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/jenpet/traebeler/internal/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDeletionGuardApply(t *testing.T) {
+	previous := toDomains("a.foo.bar", "b.foo.bar", "c.foo.bar", "d.foo.bar")
+	var guardTests = []struct {
+		name     string
+		cfg      config
+		current  []domain.Domain
+		expected []string
+	}{
+		{"removal within percentage", config{DeletionGuardPercent: 50}, toDomains("a.foo.bar", "b.foo.bar"), []string{"a.foo.bar", "b.foo.bar"}},
+		{"removal exceeding percentage", config{DeletionGuardPercent: 50}, toDomains("a.foo.bar", "new.foo.bar"), []string{"a.foo.bar", "new.foo.bar", "b.foo.bar", "c.foo.bar", "d.foo.bar"}},
+		{"removal exceeding count", config{DeletionGuardCount: 1}, toDomains("a.foo.bar", "b.foo.bar"), []string{"a.foo.bar", "b.foo.bar", "c.foo.bar", "d.foo.bar"}},
+		{"removal of everything", config{DeletionGuardPercent: 99}, toDomains(), []string{"a.foo.bar", "b.foo.bar", "c.foo.bar", "d.foo.bar"}},
+		{"removal below minimum domains", config{DeletionGuardPercent: 50, DeletionGuardMinDomains: 5}, toDomains("a.foo.bar"), []string{"a.foo.bar"}},
+		{"removal exceeding count below minimum domains", config{DeletionGuardCount: 2, DeletionGuardMinDomains: 5}, toDomains("a.foo.bar"), []string{"a.foo.bar", "b.foo.bar", "c.foo.bar", "d.foo.bar"}},
+		{"disabled limits", config{}, toDomains(), []string{}},
+		{"approved removal", config{DeletionGuardPercent: 50, DeletionGuardApprove: true}, toDomains(), []string{}},
+	}
+	for _, tt := range guardTests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newDeletionGuard(tt.cfg)
+			assert.Equal(t, tt.expected, domain.Names(g.apply(previous, tt.current)))
+		})
+	}
+}
+
+func TestDeletionGuardApply_withDefaults_shouldLetTheOnlyDomainBeRemoved(t *testing.T) {
+	defer test.ClearEnvs(test.SetEnvs(map[string]string{}))
+	cfg, err := readConfig()
+	assert.Nil(t, err)
+	g := newDeletionGuard(cfg)
+	assert.Empty(t, g.apply(toDomains("foo.bar"), toDomains()), "removing the only domain should not be refused")
+	assert.False(t, g.refused())
+	assert.Len(t, g.apply(toDomains("a.foo.bar", "b.foo.bar", "c.foo.bar"), toDomains("a.foo.bar")), 3, "removing 2 of 3 domains should be refused")
+}
+
+func TestDeletionGuardApprove_shouldOnlyLetOneRemovalThrough(t *testing.T) {
+	g := newDeletionGuard(config{DeletionGuardPercent: 50})
+	previous := toDomains("a.foo.bar", "b.foo.bar")
+	assert.Len(t, g.apply(previous, toDomains()), 2, "removal should be refused")
+	assert.Equal(t, 1, g.refusals)
+
+	g.approve()
+	assert.Empty(t, g.apply(previous, toDomains()), "approved removal should be let through")
+	assert.Equal(t, 0, g.refusals)
+	assert.Len(t, g.apply(previous, toDomains()), 2, "approval should only be used once")
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/jenpet/traebeler/internal/log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// health tracks the state of the worker loop and the processors for liveness and readiness probes. The worker is
+// considered alive as long as it finishes a cycle within the expected time. It is ready once the processors are
+// initialized and the provider was queried successfully.
+type health struct {
+	// timeout is the maximum time without a finished cycle
+	timeout time.Duration
+	now     func() time.Time
+
+	mu          sync.Mutex
+	started     time.Time
+	initialized bool
+	// queried is true after the first successful provider query
+	queried    bool
+	lastCycle  time.Time
+	lastErr    error
+	processors map[string]*processorHealth
+}
+
+// processorHealth holds the outcome of the last run of a processor.
+type processorHealth struct {
+	lastRun     time.Time
+	lastErr     error
+	lastSuccess time.Time
+	failures    []domain.RecordFailure
+	skipped     []string
+}
+
+func newHealth(timeout time.Duration) *health {
+	return &health{timeout: timeout, now: time.Now, started: time.Now(), processors: map[string]*processorHealth{}}
+}
+
+// processorsInitialized marks the processors as initialized.
+func (h *health) processorsInitialized() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.initialized = true
+}
+
+// cycleDone records the outcome of a worker cycle.
+func (h *health) cycleDone(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastCycle = h.now()
+	h.lastErr = err
+	if err == nil {
+		h.queried = true
+	}
+}
+
+// processorDone records the outcome of a processor run together with the records the processor failed to update and
+// the ones it left untouched.
+func (h *health) processorDone(id string, err error, failures []domain.RecordFailure, skipped []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ph, ok := h.processors[id]
+	if !ok {
+		ph = &processorHealth{}
+		h.processors[id] = ph
+	}
+	ph.lastRun = h.now()
+	ph.lastErr = err
+	ph.failures = failures
+	ph.skipped = skipped
+	if err == nil {
+		ph.lastSuccess = ph.lastRun
+	}
+}
+
+// healthStatus is the JSON status document of the health endpoints.
+type healthStatus struct {
+	Alive      bool                       `json:"alive"`
+	Ready      bool                       `json:"ready"`
+	LastCycle  *time.Time                 `json:"lastCycle,omitempty"`
+	LastError  string                     `json:"lastError,omitempty"`
+	Processors map[string]processorStatus `json:"processors"`
+}
+
+type processorStatus struct {
+	LastRun     time.Time  `json:"lastRun"`
+	Result      string     `json:"result"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	// SinceLastSuccess is the time since the last successful run, e.g. "1m30s"
+	SinceLastSuccess string `json:"sinceLastSuccess,omitempty"`
+	// FailingRecords are the records the processor failed to update, including quarantined ones
+	FailingRecords []domain.RecordFailure `json:"failingRecords,omitempty"`
+	// SkippedRecords are the records the processor left untouched since they are not owned by it, e.g. "sub.foo.bar (A)"
+	SkippedRecords []string `json:"skippedRecords,omitempty"`
+}
+
+func (h *health) status() healthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	activity := h.started
+	s := healthStatus{Ready: h.initialized && h.queried, Processors: map[string]processorStatus{}}
+	if !h.lastCycle.IsZero() {
+		lastCycle := h.lastCycle
+		activity = lastCycle
+		s.LastCycle = &lastCycle
+	}
+	s.Alive = now.Sub(activity) <= h.timeout
+	if h.lastErr != nil {
+		s.LastError = h.lastErr.Error()
+	}
+	for id, ph := range h.processors {
+		ps := processorStatus{LastRun: ph.lastRun, Result: "success", FailingRecords: ph.failures, SkippedRecords: ph.skipped}
+		if ph.lastErr != nil {
+			ps.Result, ps.LastError = "failure", ph.lastErr.Error()
+		}
+		if !ph.lastSuccess.IsZero() {
+			lastSuccess := ph.lastSuccess
+			ps.LastSuccess = &lastSuccess
+			ps.SinceLastSuccess = now.Sub(lastSuccess).Round(time.Second).String()
+		}
+		s.Processors[id] = ps
+	}
+	return s
+}
+
+// liveness responds with 200 as long as the worker loop is alive and 503 otherwise.
+func (h *health) liveness(w http.ResponseWriter, _ *http.Request) {
+	s := h.status()
+	writeStatus(w, s, s.Alive)
+}
+
+// readiness responds with 200 as soon as traebeler is ready and 503 otherwise.
+func (h *health) readiness(w http.ResponseWriter, _ *http.Request) {
+	s := h.status()
+	writeStatus(w, s, s.Ready)
+}
+
+func writeStatus(w http.ResponseWriter, s healthStatus, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		log.Errorf("Failed writing health status. Error: %v", err)
+	}
+}
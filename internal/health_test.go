@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthReadiness_shouldRequireInitializedProcessorsAndSuccessfulQuery(t *testing.T) {
+	h := newHealth(time.Minute)
+	assert.False(t, h.status().Ready, "not ready without initialized processors")
+
+	h.processorsInitialized()
+	h.cycleDone(errors.New("traefik unavailable"))
+	assert.False(t, h.status().Ready, "not ready without successful provider query")
+
+	h.cycleDone(nil)
+	assert.True(t, h.status().Ready)
+	h.cycleDone(errors.New("traefik unavailable"))
+	assert.True(t, h.status().Ready, "should stay ready after a later failure")
+}
+
+func TestHealthLiveness_whenNoCycleWithinTimeout_shouldNotBeAlive(t *testing.T) {
+	now := time.Now()
+	h := newHealth(time.Minute)
+	h.now = func() time.Time { return now }
+	assert.True(t, h.status().Alive, "should be alive after start")
+
+	now = now.Add(2 * time.Minute)
+	assert.False(t, h.status().Alive, "should not be alive without any cycle")
+
+	h.cycleDone(nil)
+	assert.True(t, h.status().Alive)
+	now = now.Add(30 * time.Second)
+	assert.True(t, h.status().Alive)
+	now = now.Add(time.Minute)
+	assert.False(t, h.status().Alive, "should not be alive once the last cycle exceeds the timeout")
+}
+
+func TestHealthStatus_shouldReportProcessors(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := newHealth(time.Minute)
+	h.now = func() time.Time { return now }
+	h.processorDone("froxlor", nil, nil, nil)
+	now = now.Add(90 * time.Second)
+	h.processorDone("froxlor", errors.New("froxlor unavailable"), []domain.RecordFailure{{Record: "sub.foo.bar (A)", Attempts: 3, Quarantined: true}}, []string{"foo.bar (A)"})
+	h.cycleDone(errors.New("traefik unavailable"))
+
+	s := h.status()
+	assert.Equal(t, "traefik unavailable", s.LastError)
+	ps := s.Processors["froxlor"]
+	assert.Equal(t, "failure", ps.Result)
+	assert.Equal(t, "froxlor unavailable", ps.LastError)
+	assert.Equal(t, now, ps.LastRun)
+	assert.Equal(t, now.Add(-90*time.Second), *ps.LastSuccess)
+	assert.Equal(t, "1m30s", ps.SinceLastSuccess)
+	assert.Equal(t, []domain.RecordFailure{{Record: "sub.foo.bar (A)", Attempts: 3, Quarantined: true}}, ps.FailingRecords)
+	assert.Equal(t, []string{"foo.bar (A)"}, ps.SkippedRecords)
+}
+
+func TestMux_shouldServeHealthEndpoints(t *testing.T) {
+	h := newHealth(time.Minute)
+	server := httptest.NewServer(newMux(h))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/healthz")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = http.Get(server.URL + "/readyz")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	h.processorsInitialized()
+	h.cycleDone(nil)
+	h.processorDone("froxlor", nil, nil, nil)
+	res, err = http.Get(server.URL + "/readyz")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	var s healthStatus
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&s))
+	assert.True(t, s.Alive)
+	assert.True(t, s.Ready)
+	assert.Equal(t, "success", s.Processors["froxlor"].Result)
+}
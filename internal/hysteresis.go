@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/jenpet/traebeler/internal/log"
+	"sort"
+	"time"
+)
+
+// hysteresis protects processors from flapping domains, e.g. of routers which traefik drops briefly while their
+// containers restart. A domain is only handed to the processors once it was reported by a number of consecutive polls
+// and it is kept until it was missing for a number of consecutive polls or a minimum time, whichever is configured and
+// elapses first. Right after the start no domain was reported often enough yet, hence the stable domains are only
+// meaningful once it settled.
+type hysteresis struct {
+	addAfter int
+	// removeAfter and removeAfterTime are each sufficient to remove a missing domain, 0 disables the respective one
+	removeAfter     int
+	removeAfterTime time.Duration
+	now             func() time.Time
+
+	// polls counts the polls taken into account
+	polls int
+	// stable holds the domains handed to the processors in the order they were reported
+	stable []domain.Domain
+	// sightings counts the consecutive polls a domain which is not stable yet was reported
+	sightings map[string]int
+	// absences tracks stable domains which are missing
+	absences map[string]absence
+}
+
+// absence counts the consecutive polls a domain was missing and since when.
+type absence struct {
+	polls int
+	since time.Time
+}
+
+func newHysteresis(cfg config) *hysteresis {
+	return &hysteresis{
+		addAfter:        cfg.AddAfterPolls,
+		removeAfter:     cfg.RemoveAfterPolls,
+		removeAfterTime: time.Duration(cfg.RemoveAfterSeconds) * time.Second,
+		now:             time.Now,
+		sightings:       map[string]int{},
+		absences:        map[string]absence{},
+	}
+}
+
+// apply takes the domains of the latest poll into account and returns the stable domains. Reported domains are
+// returned in their reported order followed by missing domains which are not removed yet.
+func (h *hysteresis) apply(domains []domain.Domain) []domain.Domain {
+	now := h.now()
+	h.polls++
+	known := map[string]bool{}
+	for _, d := range h.stable {
+		known[d.Name] = true
+	}
+	reported := map[string]bool{}
+	var stable []domain.Domain
+	for _, d := range domains {
+		reported[d.Name] = true
+		if known[d.Name] {
+			delete(h.absences, d.Name)
+			stable = append(stable, d)
+			continue
+		}
+		h.sightings[d.Name]++
+		if h.sightings[d.Name] >= h.addAfter {
+			delete(h.sightings, d.Name)
+			stable = append(stable, d)
+		}
+	}
+	for name := range h.sightings {
+		if !reported[name] {
+			delete(h.sightings, name)
+		}
+	}
+	for _, d := range h.stable {
+		if reported[d.Name] {
+			continue
+		}
+		a, ok := h.absences[d.Name]
+		if !ok {
+			a.since = now
+		}
+		a.polls++
+		if h.removable(a, now) {
+			delete(h.absences, d.Name)
+			continue
+		}
+		h.absences[d.Name] = a
+		stable = append(stable, d)
+	}
+	h.stable = stable
+	h.logPending()
+	return stable
+}
+
+// removable returns true in case a missing domain was missing for the configured number of polls or time. Without
+// either of them being configured a domain is removed as soon as it is missing.
+func (h *hysteresis) removable(a absence, now time.Time) bool {
+	if h.removeAfter == 0 && h.removeAfterTime == 0 {
+		return true
+	}
+	return (h.removeAfter > 0 && a.polls >= h.removeAfter) || (h.removeAfterTime > 0 && now.Sub(a.since) >= h.removeAfterTime)
+}
+
+// settled returns true once enough polls were taken into account for the domains of the first poll to become stable.
+// Before that the stable domains are incomplete and must not be handed to the processors, since they would remove the
+// records of every domain which is still pending.
+func (h *hysteresis) settled() bool {
+	return h.polls >= h.addAfter
+}
+
+// logPending logs the domains which are about to be added or removed.
+func (h *hysteresis) logPending() {
+	if len(h.sightings) > 0 {
+		var pending []string
+		for name, polls := range h.sightings {
+			pending = append(pending, name)
+			log.Debugf("Domain '%s' was reported by %d of %d required polls.", name, polls, h.addAfter)
+		}
+		sort.Strings(pending)
+		log.Infof("Domains pending to be added: %v", pending)
+	}
+	if len(h.absences) > 0 {
+		var pending []string
+		for name, a := range h.absences {
+			pending = append(pending, name)
+			log.Debugf("Domain '%s' is missing for %d polls since %s, removing it after %d polls or %s.", name, a.polls,
+				a.since.Format(time.RFC3339), h.removeAfter, h.removeAfterTime)
+		}
+		sort.Strings(pending)
+		log.Infof("Domains pending to be removed: %v", pending)
+	}
+}
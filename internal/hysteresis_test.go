@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHysteresisApply_shouldRequireConsecutivePollsBeforeAddingAndRemoving(t *testing.T) {
+	h := newHysteresis(config{AddAfterPolls: 2, RemoveAfterPolls: 2})
+	polls := []struct {
+		reported []string
+		expected []string
+	}{
+		{[]string{"foo.bar"}, []string{}},
+		{[]string{"foo.bar", "new.foo.bar"}, []string{"foo.bar"}},
+		{[]string{"new.foo.bar"}, []string{"new.foo.bar", "foo.bar"}},
+		{[]string{"foo.bar"}, []string{"foo.bar", "new.foo.bar"}},
+		{[]string{"foo.bar"}, []string{"foo.bar"}},
+	}
+	for i, poll := range polls {
+		assert.Equal(t, poll.expected, domain.Names(h.apply(toDomains(poll.reported...))), "unexpected domains after poll %d", i+1)
+	}
+}
+
+func TestHysteresisApply_whenSightingsAreInterrupted_shouldStartOver(t *testing.T) {
+	h := newHysteresis(config{AddAfterPolls: 2, RemoveAfterPolls: 1})
+	h.apply(toDomains("foo.bar"))
+	h.apply(toDomains())
+	assert.Empty(t, h.apply(toDomains("foo.bar")), "sightings have to be consecutive")
+	assert.Equal(t, []string{"foo.bar"}, domain.Names(h.apply(toDomains("foo.bar"))))
+}
+
+func TestHysteresisApply_withRemoveAfterTime_shouldKeepMissingDomainsUntilTimeElapsed(t *testing.T) {
+	h := newHysteresis(config{AddAfterPolls: 1, RemoveAfterSeconds: 60})
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	h.apply(toDomains("foo.bar"))
+
+	assert.Equal(t, []string{"foo.bar"}, domain.Names(h.apply(toDomains())), "missing domain should be kept")
+	now = now.Add(time.Second * 30)
+	assert.Equal(t, []string{"foo.bar"}, domain.Names(h.apply(toDomains())), "missing domain should be kept")
+	now = now.Add(time.Second * 30)
+	assert.Empty(t, h.apply(toDomains()), "domain should be removed once the time elapsed")
+}
+
+func TestHysteresisApply_withRemoveAfterPollsAndTime_shouldRemoveOnceEitherIsMet(t *testing.T) {
+	h := newHysteresis(config{AddAfterPolls: 1, RemoveAfterPolls: 5, RemoveAfterSeconds: 60})
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	h.apply(toDomains("foo.bar", "wiki.foo.bar"))
+
+	assert.Equal(t, []string{"wiki.foo.bar", "foo.bar"}, domain.Names(h.apply(toDomains("wiki.foo.bar"))), "missing domain should be kept")
+	now = now.Add(time.Second * 60)
+	assert.Equal(t, []string{"wiki.foo.bar"}, domain.Names(h.apply(toDomains("wiki.foo.bar"))), "domain should be removed once the time elapsed although it was only missing for 2 of 5 polls")
+
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, []string{"wiki.foo.bar"}, domain.Names(h.apply(toDomains())), "missing domain should be kept after poll %d", i+1)
+	}
+	assert.Empty(t, h.apply(toDomains()), "domain should be removed after 5 polls although the time did not elapse")
+}
+
+func TestHysteresisApply_withoutRemoveConditions_shouldRemoveMissingDomainsRightAway(t *testing.T) {
+	h := newHysteresis(config{AddAfterPolls: 1})
+	h.apply(toDomains("foo.bar"))
+	assert.Empty(t, h.apply(toDomains()))
+}
+
+func TestHysteresisApply_whenDomainReappears_shouldResetAbsence(t *testing.T) {
+	h := newHysteresis(config{AddAfterPolls: 1, RemoveAfterPolls: 2})
+	h.apply(toDomains("foo.bar"))
+	h.apply(toDomains())
+	h.apply(toDomains("foo.bar"))
+	assert.Equal(t, []string{"foo.bar"}, domain.Names(h.apply(toDomains())), "absences have to be consecutive")
+	assert.Empty(t, h.apply(toDomains()))
+}
+
+func TestHysteresisSettled_shouldRequireAddAfterPolls(t *testing.T) {
+	h := newHysteresis(config{AddAfterPolls: 3, RemoveAfterPolls: 1})
+	for i := 0; i < 2; i++ {
+		assert.Empty(t, h.apply(toDomains("foo.bar")))
+		assert.False(t, h.settled(), "hysteresis should not be settled after poll %d", i+1)
+	}
+	assert.Equal(t, []string{"foo.bar"}, domain.Names(h.apply(toDomains("foo.bar"))))
+	assert.True(t, h.settled(), "every domain of the first poll had enough polls to become stable")
+}
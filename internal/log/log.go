@@ -59,6 +59,11 @@ func Infof(format string, args ...interface{}) {
 	logger.Infof(format, args...)
 }
 
+// Warnf logs a message at level Warn on the standard logger.
+func Warnf(format string, args ...interface{}) {
+	logger.Warnf(format, args...)
+}
+
 // Errorf logs a message at level Error on the standard logger.
 func Errorf(format string, args ...interface{}) {
 	logger.Errorf(format, args...)
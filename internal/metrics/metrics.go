@@ -0,0 +1,201 @@
+// Package metrics exposes the Prometheus metrics of traebeler. All metrics are registered on the default registry.
+package metrics
+
+import (
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const namespace = "traebeler"
+
+// record operations of processors
+const (
+	RecordCreated = "created"
+	RecordUpdated = "updated"
+	RecordDeleted = "deleted"
+	RecordDrifted = "drifted"
+	RecordSkipped = "skipped"
+	RecordFailed  = "failed"
+)
+
+var (
+	cycles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cycles_total",
+		Help:      "Number of reconciliation cycles by result.",
+	}, []string{"result"})
+	cycleDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cycle_duration_seconds",
+		Help:      "Duration of querying the domains and public addresses of a reconciliation cycle.",
+	})
+	domains = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "domains",
+		Help:      "Number of domains discovered in the last cycle by the provider of their routers.",
+	}, []string{"provider"})
+	processorRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "processor_runs_total",
+		Help:      "Number of processor runs by processor and result.",
+	}, []string{"processor", "result"})
+	processorDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "processor_run_duration_seconds",
+		Help:      "Duration of processor runs.",
+	}, []string{"processor"})
+	lastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful run of a processor.",
+	}, []string{"processor"})
+	records = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "records_total",
+		Help:      "Number of records created, updated, deleted, drifted, skipped and failed by processor.",
+	}, []string{"processor", "operation"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of requests towards external APIs by command.",
+	}, []string{"api", "command"})
+	requestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_request_errors_total",
+		Help:      "Number of failed requests towards external APIs by command.",
+	}, []string{"api", "command"})
+	requestRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_request_retries_total",
+		Help:      "Number of requests towards external APIs retried due to a transient error by command.",
+	}, []string{"api", "command"})
+	failingRecords = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "failing_records",
+		Help:      "Number of records a processor failed to update which are waiting for their next attempt.",
+	}, []string{"processor"})
+	quarantinedRecords = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "quarantined_records",
+		Help:      "Number of records which kept failing and are only retried rarely.",
+	}, []string{"processor"})
+	publicIP = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "public_ip_info",
+		Help:      "The currently detected public address by IP version.",
+	}, []string{"family", "ip"})
+	guardBlocked = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deletion_guard_blocked",
+		Help:      "1 in case the deletion guard currently refuses a removal of domains.",
+	})
+	guardRefusals = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deletion_guard_refusals_total",
+		Help:      "Number of cycles the deletion guard refused a removal of domains.",
+	})
+
+	mu sync.Mutex
+	// ips holds the address exposed per family to drop its series once it changes
+	ips = map[string]string{}
+)
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveCycle records a reconciliation cycle which started at the given time.
+func ObserveCycle(start time.Time, err error) {
+	cycleDuration.Observe(time.Since(start).Seconds())
+	cycles.WithLabelValues(result(err)).Inc()
+}
+
+// SetDomains exposes the number of domains per provider of their routers. Domains without routers are counted for an
+// empty provider.
+func SetDomains(ds []domain.Domain) {
+	counts := map[string]int{}
+	for _, d := range ds {
+		providers := map[string]bool{}
+		for _, r := range d.Routers {
+			providers[r.Provider] = true
+		}
+		if len(providers) == 0 {
+			providers[""] = true
+		}
+		for provider := range providers {
+			counts[provider]++
+		}
+	}
+	domains.Reset()
+	for provider, count := range counts {
+		domains.WithLabelValues(provider).Set(float64(count))
+	}
+}
+
+// ObserveProcessorRun records a processor run which started at the given time.
+func ObserveProcessorRun(processor string, start time.Time, err error) {
+	processorDuration.WithLabelValues(processor).Observe(time.Since(start).Seconds())
+	processorRuns.WithLabelValues(processor, result(err)).Inc()
+	if err == nil {
+		lastSuccess.WithLabelValues(processor).SetToCurrentTime()
+	}
+}
+
+// RecordOperation counts a record operation of a processor.
+func RecordOperation(processor, operation string) {
+	records.WithLabelValues(processor, operation).Inc()
+}
+
+// ObserveRequest records a request towards an external API which started at the given time.
+func ObserveRequest(api, command string, start time.Time, err error) {
+	requestDuration.WithLabelValues(api, command).Observe(time.Since(start).Seconds())
+	if err != nil {
+		requestErrors.WithLabelValues(api, command).Inc()
+	}
+}
+
+// RetryRequest counts a request towards an external API which is retried.
+func RetryRequest(api, command string) {
+	requestRetries.WithLabelValues(api, command).Inc()
+}
+
+// SetFailingRecords exposes the number of failing and quarantined records of a processor.
+func SetFailingRecords(processor string, failing, quarantined int) {
+	failingRecords.WithLabelValues(processor).Set(float64(failing))
+	quarantinedRecords.WithLabelValues(processor).Set(float64(quarantined))
+}
+
+// SetPublicIP exposes the detected public address of an IP version.
+func SetPublicIP(family, ip string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if previous, ok := ips[family]; ok && previous != ip {
+		publicIP.DeleteLabelValues(family, previous)
+	}
+	ips[family] = ip
+	publicIP.WithLabelValues(family, ip).Set(1)
+}
+
+// DeletionGuardRefused records a removal refused by the deletion guard.
+func DeletionGuardRefused() {
+	guardRefusals.Inc()
+	guardBlocked.Set(1)
+}
+
+// DeletionGuardPassed records that the deletion guard does not refuse any removal.
+func DeletionGuardPassed() {
+	guardBlocked.Set(0)
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSetDomains_shouldCountDomainsPerProviderOfTheirRouters(t *testing.T) {
+	SetDomains([]domain.Domain{
+		{Name: "foo.bar", Routers: []domain.Router{{Name: "foo@docker", Provider: "docker"}, {Name: "foo@file", Provider: "file"}}},
+		{Name: "wiki.foo.bar", Routers: []domain.Router{{Name: "wiki@docker", Provider: "docker"}, {Name: "wiki-lan@docker", Provider: "docker"}}},
+		{Name: "static.foo.bar"},
+	})
+	assert.Equal(t, float64(2), testutil.ToFloat64(domains.WithLabelValues("docker")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(domains.WithLabelValues("file")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(domains.WithLabelValues("")))
+
+	SetDomains(nil)
+	assert.Equal(t, 0, testutil.CollectAndCount(domains), "vanished providers should not be exposed anymore")
+}
+
+func TestSetPublicIP_shouldOnlyExposeCurrentAddress(t *testing.T) {
+	SetPublicIP("IPv4", "93.184.216.34")
+	SetPublicIP("IPv6", "2606:2800:220:1:248:1893:25c8:1946")
+	SetPublicIP("IPv4", "93.184.216.35")
+	assert.Equal(t, 2, testutil.CollectAndCount(publicIP))
+	assert.Equal(t, float64(1), testutil.ToFloat64(publicIP.WithLabelValues("IPv4", "93.184.216.35")))
+}
+
+func TestObserveProcessorRun_shouldOnlySetLastSuccessOnSuccess(t *testing.T) {
+	processorRuns.Reset()
+	lastSuccess.Reset()
+	ObserveProcessorRun("test", time.Now(), errors.New("processor error"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(processorRuns.WithLabelValues("test", "failure")))
+	assert.Equal(t, 0, testutil.CollectAndCount(lastSuccess))
+
+	ObserveProcessorRun("test", time.Now(), nil)
+	assert.Equal(t, float64(1), testutil.ToFloat64(processorRuns.WithLabelValues("test", "success")))
+	assert.NotZero(t, testutil.ToFloat64(lastSuccess.WithLabelValues("test")))
+}
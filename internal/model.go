@@ -1,23 +1,71 @@
 package internal
 
-import "time"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jenpet/traebeler/internal/domain"
+	"strings"
+	"time"
+)
 
 // clock is used to have a configuredClock based ticker channel and a possibility to stop it.
 type clock interface {
 	Ticker() <-chan time.Time
 }
 
-// provider provides a list of domains which can be used for processing.
+// provider provides a list of domains which can be used for processing. An error indicates that the domains could not
+// be determined at all, which must not be confused with an empty list of domains. The query is aborted once the context
+// is done.
 type provider interface {
-	GetDomains() []string
+	GetDomains(ctx context.Context) ([]domain.Domain, error)
 }
 
-// processor works on a list of domains and identifies itself via an ID.
+// processor works on a list of domains and identifies itself via an ID. Besides their names the domains describe the
+// routers they originate from, e.g. their provider, entrypoints and TLS configuration. Processing is aborted once the
+// context is done.
 type processor interface {
-	Process(domains []string)
+	Process(ctx context.Context, domains []domain.Domain) error
 	ID() string
 }
 
+// changeProcessor is a processor which receives the changes since its last successful run instead of the full list of
+// domains. The full list is still part of the change set. Processors may use the changes to skip a run or to limit their
+// work, e.g. the Froxlor processor only uses them to skip unchanged runs and takes the watched addresses.
+type changeProcessor interface {
+	ProcessChanges(ctx context.Context, changes domain.ChangeSet) error
+}
+
+// dryRunProcessor is a processor which is able to report its planned changes instead of applying them. Dry runs are
+// enabled before processors are initialized.
+type dryRunProcessor interface {
+	EnableDryRun()
+	// Plan returns the changes the last run would have applied
+	Plan() []string
+}
+
+// failureReporter is a processor which keeps track of records it failed to update.
+type failureReporter interface {
+	Failures() []domain.RecordFailure
+}
+
+// skipReporter is a processor which leaves records untouched which it does not own.
+type skipReporter interface {
+	Skipped() []string
+}
+
+// addressSource detects the public addresses whose changes are handed to the processors. Addresses which are not
+// watched or could not be detected are empty. The detection is aborted once the context is done.
+type addressSource interface {
+	Addresses(ctx context.Context) (ipv4, ipv6 string)
+}
+
+// stateStore persists state across restarts, see the state package.
+type stateStore interface {
+	Load(v interface{}) (bool, error)
+	Save(v interface{}) error
+}
+
 // realClock implements the clock interface having a time.Ticker internally for re-occurring signals
 type realClock struct {
 	ticker *time.Ticker
@@ -31,11 +79,92 @@ func (rt *realClock) Ticker() <- chan time.Time {
 // by utilizing annotations for default values
 type config struct {
 	LookupInterval int `split_words:"true" default:"30"` // default of 30 secs
-	Processor string `default:""`
+	// Processors holds the IDs of all processors every set of domains is handed to, e.g. "froxlor,other"
+	Processors []string `envconfig:"processor"`
+	// AddAfterPolls is the number of consecutive polls a domain has to be reported before it is handed to processors
+	AddAfterPolls int `split_words:"true" default:"1"`
+	// RemoveAfterPolls and RemoveAfterSeconds define how long a domain has to be missing before it counts as removed,
+	// each of them is sufficient on its own and 0 disables it
+	RemoveAfterPolls   int `split_words:"true" default:"0"`
+	RemoveAfterSeconds int `split_words:"true" default:"0"`
+	// DeletionGuardPercent and DeletionGuardCount limit the domains removed at once, 0 disables the respective limit
+	DeletionGuardPercent int `split_words:"true" default:"50"`
+	DeletionGuardCount   int `split_words:"true" default:"0"`
+	// DeletionGuardMinDomains is the number of previous domains below which the percentage limit does not apply
+	DeletionGuardMinDomains int `split_words:"true" default:"3"`
+	// DeletionGuardApprove approves the first removal exceeding the limits
+	DeletionGuardApprove bool `split_words:"true"`
+	// HTTPAddress is the address the HTTP endpoints are served on, empty to disable them
+	HTTPAddress string `envconfig:"http_address" default:":8080"`
+	// LivenessTimeout is the time in seconds a cycle may take longer than the lookup interval before traebeler is
+	// considered to be wedged
+	LivenessTimeout int `split_words:"true" default:"300"`
+	// CycleTimeout is the time in seconds a single provider query or processor run may take before it is aborted
+	CycleTimeout int `split_words:"true" default:"60"`
+	// ShutdownGracePeriod is the time in seconds processor runs in progress may take to finish during a shutdown
+	ShutdownGracePeriod int `split_words:"true" default:"30"`
+	// DryRun makes all processors report their planned changes instead of applying them
+	DryRun bool `split_words:"true"`
+}
+
+// validate returns an error naming the environment variable and the value of every option which is out of range.
+func (wc config) validate() error {
+	var errs []string
+	if len(wc.Processors) == 0 {
+		errs = append(errs, "TRAEBELER_PROCESSOR has to define at least one processor")
+	}
+	var limits = []struct {
+		env   string
+		value int
+		min   int
+		// max is the upper limit, 0 in case there is none
+		max int
+	}{
+		{"TRAEBELER_LOOKUP_INTERVAL", wc.LookupInterval, 1, 0},
+		{"TRAEBELER_ADD_AFTER_POLLS", wc.AddAfterPolls, 1, 0},
+		{"TRAEBELER_REMOVE_AFTER_POLLS", wc.RemoveAfterPolls, 0, 0},
+		{"TRAEBELER_REMOVE_AFTER_SECONDS", wc.RemoveAfterSeconds, 0, 0},
+		{"TRAEBELER_DELETION_GUARD_PERCENT", wc.DeletionGuardPercent, 0, 100},
+		{"TRAEBELER_DELETION_GUARD_COUNT", wc.DeletionGuardCount, 0, 0},
+		{"TRAEBELER_DELETION_GUARD_MIN_DOMAINS", wc.DeletionGuardMinDomains, 0, 0},
+		{"TRAEBELER_LIVENESS_TIMEOUT", wc.LivenessTimeout, 1, 0},
+		{"TRAEBELER_CYCLE_TIMEOUT", wc.CycleTimeout, 1, 0},
+		{"TRAEBELER_SHUTDOWN_GRACE_PERIOD", wc.ShutdownGracePeriod, 0, 0},
+	}
+	for _, l := range limits {
+		switch {
+		case l.max > 0 && (l.value < l.min || l.value > l.max):
+			errs = append(errs, fmt.Sprintf("%s has to be between %d and %d but is %d", l.env, l.min, l.max, l.value))
+		case l.value < l.min:
+			errs = append(errs, fmt.Sprintf("%s has to be at least %d but is %d", l.env, l.min, l.value))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// normalize trims the configured processor IDs and drops empty entries as well as duplicates.
+func (wc *config) normalize() {
+	var ids []string
+	for _, id := range wc.Processors {
+		id = strings.TrimSpace(id)
+		if id == "" || contains(ids, id) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	wc.Processors = ids
 }
 
-func (wc config) valid() bool {
-	return wc.LookupInterval > 0 && wc.Processor != ""
+func contains(haystack []string, needle string) bool {
+	for _, element := range haystack {
+		if element == needle {
+			return true
+		}
+	}
+	return false
 }
 
 // configuredClock returns a clock based on the provided traebeler configuration
@@ -2,49 +2,76 @@ package froxlor
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/jenpet/traebeler/internal/log"
+	"github.com/jenpet/traebeler/internal/metrics"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const froxlorAPIPath = "/froxlor/api.php"
 
+// requestRetryDelay is the delay before the first retry of a request failing due to a transient error, it is doubled
+// with every further retry
+var requestRetryDelay = 500 * time.Millisecond
+
 type froxlorApi struct {
 	uri, key, secret string
 	action apiAction
+	// limiter limits the requests per second, nil for no limit
+	limiter *rateLimiter
+	// retries is the number of times a request failing due to a transient error is retried
+	retries int
 }
 
-func (fa froxlorApi) findDomainZones(domain, record string) ([]zone, error) {
+func (fa froxlorApi) findDomainZones(ctx context.Context, domain, record string) ([]zone, error) {
 	body := zoneListBody{}
-	err := fa.post(createFindBodyContent(domain, record), &body)
+	err := fa.post(ctx, createFindBodyContent(domain, record), &body)
 	return body.Data.List, err
 }
 
-func (fa froxlorApi) deleteDomainZone(domain, entryID string) error {
+func (fa froxlorApi) deleteDomainZone(ctx context.Context, domain, entryID string) error {
 	body := responseBody{}
-	return fa.post(createDeleteBodyContent(domain, entryID), &body)
+	return fa.post(ctx, createDeleteBodyContent(domain, entryID), &body)
 }
 
-func (fa froxlorApi) addDomainZone(domain, record, content, ttl, rtype string) error {
+func (fa froxlorApi) addDomainZone(ctx context.Context, domain, record, content, ttl, rtype string) error {
 	body := responseBody{}
-	return fa.post(createAddBodyContent(domain, record, content, ttl, rtype), &body)
+	return fa.post(ctx, createAddBodyContent(domain, record, content, ttl, rtype), &body)
+}
+
+// claim does nothing since the API does not keep track of ownership.
+func (fa froxlorApi) claim(_ record) {}
+
+// planned returns false since every call is performed.
+func (fa froxlorApi) planned() bool {
+	return false
 }
 
-func (fa froxlorApi) domainExists(fqn string) (bool, error) {
+func (fa froxlorApi) domainExists(ctx context.Context, fqn string) (bool, error) {
 	body := listBody{}
-	err := fa.post(createFindSubDomainBodyContent(fqn), &body)
+	err := fa.post(ctx, createFindSubDomainBodyContent(fqn), &body)
 	return body.Data.Count > 0, err
 }
 
-func (fa froxlorApi) addDomain(domain, subdomain string) error {
+func (fa froxlorApi) addDomain(ctx context.Context, domain, subdomain string) error {
 	body := responseBody{}
-	return fa.post(createAddSubDomainContent(domain, subdomain), &body)
+	return fa.post(ctx, createAddSubDomainContent(domain, subdomain), &body)
 }
 
-func (fa froxlorApi) post(content requestBodyContent, responseBody froxlorBody) error {
+func (fa froxlorApi) deleteDomain(ctx context.Context, fqn string) error {
+	body := responseBody{}
+	return fa.post(ctx, createDeleteSubDomainContent(fqn), &body)
+}
+
+func (fa froxlorApi) post(ctx context.Context, content requestBodyContent, responseBody froxlorBody) error {
 	body := requestBody{
 		Header: requestBodyHeader{
 			APIKey: fa.key,
@@ -56,33 +83,72 @@ func (fa froxlorApi) post(content requestBodyContent, responseBody froxlorBody)
 	if err != nil {
 		return err
 	}
+	delay := requestRetryDelay
+	for attempt := 0; ; attempt++ {
+		transient, err := fa.send(ctx, content.Command, b, responseBody)
+		if err == nil || !transient || !retryable(content.Command, err) || attempt >= fa.retries || ctx.Err() != nil {
+			return err
+		}
+		log.Infof("Retrying Froxlor command '%s' in %v due to a transient error. Error: %v", content.Command, delay, err)
+		metrics.RetryRequest("froxlor", content.Command)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// retryable returns true in case a request of the given command which failed due to a transient error may be sent
+// again. Requests of mutating commands may have been applied already, e.g. in case the response got lost, and a
+// repeated addition would create a duplicate. Therefore they are only retried in case they were never sent since the
+// connection could not be established, listings are retried after any transient error.
+func retryable(command string, err error) bool {
+	if strings.HasSuffix(command, ".listing") {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// send performs a single request holding the given body. The returned bool is true in case the error is transient and
+// the request may succeed when it is retried, e.g. due to a network error or a 5xx response.
+func (fa froxlorApi) send(ctx context.Context, command string, b []byte, responseBody froxlorBody) (transient bool, err error) {
+	if err := fa.limiter.wait(ctx); err != nil {
+		return false, err
+	}
+	defer func(start time.Time) {
+		metrics.ObserveRequest("froxlor", command, start, err)
+	}(time.Now())
 	uri := createURI(fa.uri, froxlorAPIPath)
-	resp, err := fa.action(uri, "application/json", bytes.NewBuffer(b))
+	resp, err := fa.action(ctx, uri, "application/json", bytes.NewBuffer(b))
 	if err != nil {
-		return err
+		return true, err
 	}
 	defer resp.Body.Close()
+	transient = resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
 	// parse response body to extract the "body status code" and the status message if applicable
 	b, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return true, err
 	}
 	// there was a body which might be JSON and provide a status
 	if len(b) > 0 {
 		err = json.Unmarshal(b, &responseBody)
 		if err != nil {
-			return err
+			return transient, err
 		}
 
 		// check response HTTP status code and body status code
 		if resp.StatusCode != http.StatusOK || responseBody.statusCode() != http.StatusOK {
-			return fmt.Errorf("froxlor API HTTP response code is '%d' and body response code '%d' with reason '%s'",
+			return transient, fmt.Errorf("froxlor API HTTP response code is '%d' and body response code '%d' with reason '%s'",
 				resp.StatusCode, responseBody.statusCode(), responseBody.statusMessage())
 		}
 	} else if resp.StatusCode != http.StatusNotModified {
-		return fmt.Errorf("froxlor API returned no body http status code %d", resp.StatusCode)
+		return transient, fmt.Errorf("froxlor API returned no body http status code %d", resp.StatusCode)
 	}
-	return nil
+	return false, nil
 }
 
 func createURI(baseURI, apiPath string) string {
@@ -178,6 +244,15 @@ func createAddSubDomainContent(domain, subdomain string) requestBodyContent {
 	}
 }
 
+func createDeleteSubDomainContent(fqn string) requestBodyContent {
+	return requestBodyContent{
+		Command: "SubDomains.delete",
+		Params:  map[string]interface{}{
+			"domainname": fqn,
+		},
+	}
+}
+
 type froxlorBody interface {
 	statusCode() int
 	statusMessage() string
@@ -220,4 +295,14 @@ type zone struct {
 	Content string `json:"content"`
 }
 
-type apiAction func(url, contentType string, body io.Reader) (resp *http.Response, err error)
\ No newline at end of file
+type apiAction func(ctx context.Context, url, contentType string, body io.Reader) (resp *http.Response, err error)
+
+// httpPost posts the body to the given URL. The request is aborted once the context is done.
+func httpPost(ctx context.Context, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return http.DefaultClient.Do(req)
+}
\ No newline at end of file
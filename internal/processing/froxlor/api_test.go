@@ -1,13 +1,17 @@
 package froxlor
 
 import (
+	"context"
 	"bytes"
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"testing"
+	"time"
 )
 
 var mf = mockFroxlor{}
@@ -55,7 +59,7 @@ func TestFind_shouldCallAndReturnListOfZones(t *testing.T) {
 		mf.reset()
 		tt.mocks()
 		t.Run(tt.name, func(t *testing.T) {
-			zones, err := api.findDomainZones("foo.bar", "@")
+			zones, err := api.findDomainZones(context.Background(), "foo.bar", "@")
 			assert.Equal(t, tt.errorExpected, err != nil, "expected error to be '%v' but was '%v'", tt.errorExpected, err != nil)
 			if !tt.errorExpected {
 				assert.Len(t, zones, len(tt.expectedZones))
@@ -94,7 +98,7 @@ func TestDelete_shouldReturnErrorInCaseFailed(t *testing.T) {
 		mf.reset()
 		tt.mocks()
 		t.Run(tt.name, func(t *testing.T) {
-			err := api.deleteDomainZone("foo.bar", "id")
+			err := api.deleteDomainZone(context.Background(), "foo.bar", "id")
 			assert.Equal(t, tt.errorExpected, err != nil, "expected error to be '%v' but was '%v'", tt.errorExpected, err)
 		})
 	}
@@ -126,7 +130,34 @@ func TestAdd_shouldReturnErrorInCaseFailed(t *testing.T) {
 		mf.reset()
 		tt.mocks()
 		t.Run(tt.name, func(t *testing.T) {
-			err := api.addDomainZone("foo.bar", "record", "127.0.0.1", "18000", "A")
+			err := api.addDomainZone(context.Background(), "foo.bar", "record", "127.0.0.1", "18000", "A")
+			assert.Equal(t, tt.errorExpected, err != nil, "expected error to be '%v' but was '%v'", tt.errorExpected, err)
+		})
+	}
+}
+
+func TestDeleteDomain_shouldReturnErrorInCaseFailed(t *testing.T) {
+	deleteTests := []struct{
+		name string
+		mocks func()
+		errorExpected bool
+	}{
+		{
+			name: "delete subdomain success",
+			mocks: func() {mf.mockResponse(http.StatusOK, "subdomain_delete_success.json", nil) },
+			errorExpected: false,
+		},
+		{
+			name: "delete missing subdomain",
+			mocks: func() {mf.mockResponse(http.StatusNotFound, "subdomain_delete_not_found.json", nil) },
+			errorExpected: true,
+		},
+	}
+	for _, tt := range deleteTests {
+		mf.reset()
+		tt.mocks()
+		t.Run(tt.name, func(t *testing.T) {
+			err := api.deleteDomain(context.Background(), "sub.foo.bar")
 			assert.Equal(t, tt.errorExpected, err != nil, "expected error to be '%v' but was '%v'", tt.errorExpected, err)
 		})
 	}
@@ -154,12 +185,104 @@ func TestCreateURI_whenURIHasTrailingSlash_shouldTrim(t *testing.T) {
 	}
 }
 
+func TestPost_whenTransientError_shouldRetry(t *testing.T) {
+	defer func(delay time.Duration) { requestRetryDelay = delay }(requestRetryDelay)
+	requestRetryDelay = time.Millisecond
+	retryingApi := api
+	retryingApi.retries = 2
+	listing := func() error {
+		_, err := retryingApi.findDomainZones(context.Background(), "foo.bar", "@")
+		return err
+	}
+	addition := func() error {
+		return retryingApi.addDomainZone(context.Background(), "foo.bar", "@", "127.0.0.1", "18000", "A")
+	}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	retryTests := []struct {
+		name             string
+		call             func() error
+		mocks            func()
+		expectedRequests int
+		errorExpected    bool
+	}{
+		{
+			name: "success after server errors",
+			call: listing,
+			mocks: func() {
+				mf.mockResponse(http.StatusServiceUnavailable, "", nil)
+				mf.mockResponse(http.StatusBadGateway, "", nil)
+				mf.mockResponse(http.StatusOK, "domainzone_listing_successful.json", nil)
+			},
+			expectedRequests: 3,
+		},
+		{
+			name: "network error",
+			call: listing,
+			mocks: func() {
+				mf.mockResponse(0, "", errors.New("connection reset"))
+				mf.mockResponse(http.StatusOK, "domainzone_listing_successful.json", nil)
+			},
+			expectedRequests: 2,
+		},
+		{
+			name: "retries exhausted",
+			call: listing,
+			mocks: func() {
+				for i := 0; i < 3; i++ {
+					mf.mockResponse(http.StatusInternalServerError, "", nil)
+				}
+			},
+			expectedRequests: 3,
+			errorExpected:    true,
+		},
+		{
+			name:             "client error is not retried",
+			call:             listing,
+			mocks:            func() { mf.mockResponse(http.StatusBadRequest, "", nil) },
+			expectedRequests: 1,
+			errorExpected:    true,
+		},
+		{
+			name:             "addition is not retried after server error since it may have been applied",
+			call:             addition,
+			mocks:            func() { mf.mockResponse(http.StatusServiceUnavailable, "", nil) },
+			expectedRequests: 1,
+			errorExpected:    true,
+		},
+		{
+			name:             "addition is not retried after network error since it may have been applied",
+			call:             addition,
+			mocks:            func() { mf.mockResponse(0, "", errors.New("connection reset")) },
+			expectedRequests: 1,
+			errorExpected:    true,
+		},
+		{
+			name: "addition is retried in case the connection was refused",
+			call: addition,
+			mocks: func() {
+				mf.mockResponse(0, "", &url.Error{Op: "Post", URL: "https://froxlor.example.com", Err: refused})
+				mf.mockResponse(http.StatusOK, "domainzone_add_success.json", nil)
+			},
+			expectedRequests: 2,
+		},
+	}
+	for _, tt := range retryTests {
+		mf.reset()
+		tt.mocks()
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			assert.Equal(t, tt.errorExpected, err != nil, "expected error to be '%v' but was '%v'", tt.errorExpected, err)
+			assert.Len(t, mf.requests, tt.expectedRequests)
+		})
+	}
+}
+
 type mockFroxlor struct {
 	requests []actionRequest
 	responses []actionResponse
 }
 
-func (mf *mockFroxlor) mockAction(url, contentType string, body io.Reader) (resp *http.Response, err error) {
+func (mf *mockFroxlor) mockAction(_ context.Context, url, contentType string, body io.Reader) (resp *http.Response, err error) {
 	if mf.requests == nil {
 		mf.requests = []actionRequest{}
 	}
@@ -0,0 +1,66 @@
+package froxlor
+
+import (
+	"context"
+	"fmt"
+	"github.com/jenpet/traebeler/internal/metrics"
+	"sync"
+)
+
+// dryRunHandler records the mutating calls towards Froxlor as plan instead of performing them. Lookups are still
+// forwarded, hence the plan is based on the actual state of Froxlor.
+type dryRunHandler struct {
+	froxlorHandler
+	mu    sync.Mutex
+	steps []string
+}
+
+func (dh *dryRunHandler) addDomainZone(_ context.Context, domain, record, content, ttl, rtype string) error {
+	dh.record(fmt.Sprintf("add %s record '%s' of domain '%s' with content '%s' and TTL %s", rtype, record, domain, content, ttl))
+	return nil
+}
+
+func (dh *dryRunHandler) deleteDomainZone(_ context.Context, domain, entryID string) error {
+	dh.record(fmt.Sprintf("delete zone record with ID '%s' of domain '%s'", entryID, domain))
+	return nil
+}
+
+func (dh *dryRunHandler) addDomain(_ context.Context, domain, subdomain string) error {
+	dh.record(fmt.Sprintf("add subdomain '%s' of domain '%s'", subdomain, domain))
+	return nil
+}
+
+func (dh *dryRunHandler) deleteDomain(_ context.Context, fqn string) error {
+	dh.record(fmt.Sprintf("delete subdomain '%s'", fqn))
+	return nil
+}
+
+func (dh *dryRunHandler) record(step string) {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+	dh.steps = append(dh.steps, step)
+}
+
+// planned returns true since the handler only plans the mutating calls instead of performing them. Planned calls
+// must neither change the cache, ownership and sync state of the processor nor be counted as performed.
+func (dh *dryRunHandler) planned() bool {
+	return true
+}
+
+// recordOperation counts an operation on a record in case the handler performs its calls. A dry run does not count
+// any operation, neither planned changes nor skipped or failed records.
+func recordOperation(rh recordHandler, operation string) {
+	if rh.planned() {
+		return
+	}
+	metrics.RecordOperation(processorID, operation)
+}
+
+// plan returns the recorded calls and starts a new plan.
+func (dh *dryRunHandler) plan() []string {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+	steps := dh.steps
+	dh.steps = nil
+	return steps
+}
@@ -0,0 +1,137 @@
+package froxlor
+
+import (
+	"context"
+	"errors"
+	"github.com/jenpet/traebeler/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestProcess_whenDryRunEnabled_shouldOnlyLookupAndReportPlannedCalls(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	mfh.existsMock = func(fqn string) (bool, error) { return fqn == "foo.bar", nil }
+	mfh.findMock = func(domain, record string) ([]zone, error) {
+		return []zone{{"97", "1337", "18000", "@", "A", "93.184.216.35"}}, nil
+	}
+	p := Processor{cfg: config{IPv4: true}, ip: mockIpProvider{}, owned: newRegistry()}
+	p.EnableDryRun()
+	p.dryRun.froxlorHandler = &mfh
+	p.api = p.dryRun
+
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar", "sub.foo.bar")))
+	assert.Equal(t, 0, mfh.addInteractions, "no zone should be added")
+	assert.Equal(t, 0, mfh.deleteInteractions, "no zone should be deleted")
+	assert.NotZero(t, mfh.findInteractions, "zones should still be looked up")
+	assert.NotZero(t, mfh.mockDomainHandler.interactions, "subdomains should still be looked up")
+	assert.Empty(t, mfh.deletedDomains, "no subdomain should be deleted")
+	assert.Empty(t, p.dryRun.plan(), "the plan should be reset after it was reported")
+}
+
+func TestProcess_whenDryRunEnabled_shouldPlanSameChangesEveryRun(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	mfh.findMock = func(domain, record string) ([]zone, error) {
+		if record == "old" {
+			return []zone{{"98", "1337", "18000", "old", "A", "127.0.0.1"}}, nil
+		}
+		return nil, nil
+	}
+	p := Processor{
+		cfg:    config{IPv4: true, GarbageCollect: true},
+		ip:     mockIpProvider{},
+		owned:  newRegistry(),
+		cache:  []record{},
+		resync: newResyncer(time.Hour, 0),
+	}
+	p.owned.zoneAdded(record{"foo.bar", "old", "127.0.0.1", "A"})
+	p.EnableDryRun()
+	p.dryRun.froxlorHandler = &mfh
+	p.api = p.dryRun
+
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")))
+	plan := p.Plan()
+	assert.Equal(t, []string{
+		"add A record '@' of domain 'foo.bar' with content '127.0.0.1' and TTL 18000",
+		"delete zone record with ID '98' of domain 'foo.bar'",
+	}, plan)
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")))
+	assert.Equal(t, plan, p.Plan(), "planned changes should not be considered applied")
+	assert.Empty(t, p.cache, "planned records should not be cached")
+	assert.Empty(t, p.synced, "planned records should not be synced")
+	assert.Equal(t, map[string]record{"old.foo.bar/A": {"foo.bar", "old", "127.0.0.1", "A"}}, p.owned.records, "ownership should not change")
+	assert.Equal(t, 0, mfh.addInteractions)
+	assert.Equal(t, 0, mfh.deleteInteractions)
+}
+
+func TestProcess_whenDryRunEnabled_shouldNotCountSkippedOrFailedRecords(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	mfh.existsMock = func(fqn string) (bool, error) { return true, nil }
+	mfh.findMock = func(domain, record string) ([]zone, error) {
+		switch record {
+		case "_traebeler", "_traebeler.sub":
+			return nil, nil
+		case "broken":
+			return nil, errors.New("froxlor error")
+		}
+		return []zone{{"98", "1337", "18000", record, "A", "192.168.178.1"}}, nil
+	}
+	p := Processor{
+		cfg:      config{IPv4: true},
+		ip:       mockIpProvider{},
+		owned:    newRegistry(),
+		registry: &txtRegistry{instanceID: "test"},
+	}
+	p.EnableDryRun()
+	p.dryRun.froxlorHandler = &mfh
+	p.api = p.dryRun
+	skipped, failed := recordsTotal(t, metrics.RecordSkipped), recordsTotal(t, metrics.RecordFailed)
+
+	assert.NotNil(t, p.Process(context.Background(), toDomains("sub.foo.bar", "broken.foo.bar")))
+	assert.Equal(t, []string{"sub.foo.bar (A)"}, p.Skipped(), "the unmarked record should be skipped")
+	assert.Equal(t, skipped, recordsTotal(t, metrics.RecordSkipped), "skipped records should not be counted during a dry run")
+	assert.Equal(t, failed, recordsTotal(t, metrics.RecordFailed), "failed records should not be counted during a dry run")
+}
+
+// recordsTotal returns the number of record operations of the Froxlor processor counted so far.
+func recordsTotal(t *testing.T, operation string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() != "traebeler_records_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["processor"] == processorID && labels["operation"] == operation {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestDryRunHandler_shouldRecordMutatingCalls(t *testing.T) {
+	dh := &dryRunHandler{froxlorHandler: &mockFroxlorHandler{}}
+	assert.Nil(t, dh.addDomain(context.Background(), "foo.bar", "sub"))
+	assert.Nil(t, dh.deleteDomainZone(context.Background(), "foo.bar", "97"))
+	assert.Nil(t, dh.addDomainZone(context.Background(), "foo.bar", "sub", "93.184.216.34", "18000", "A"))
+	assert.Nil(t, dh.deleteDomain(context.Background(), "old.foo.bar"))
+	assert.Equal(t, []string{
+		"add subdomain 'sub' of domain 'foo.bar'",
+		"delete zone record with ID '97' of domain 'foo.bar'",
+		"add A record 'sub' of domain 'foo.bar' with content '93.184.216.34' and TTL 18000",
+		"delete subdomain 'old.foo.bar'",
+	}, dh.plan())
+	assert.Empty(t, dh.plan())
+}
+
+func TestPlanned_shouldBeReportedThroughWrappingHandlers(t *testing.T) {
+	dh := &dryRunHandler{froxlorHandler: &mockFroxlorHandler{}}
+	assert.True(t, trackingHandler{froxlorHandler: dh, owned: newRegistry()}.planned())
+	assert.False(t, trackingHandler{froxlorHandler: &mockFroxlorHandler{}, owned: newRegistry()}.planned())
+}
@@ -0,0 +1,178 @@
+package froxlor
+
+import (
+	"fmt"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/jenpet/traebeler/internal/log"
+	"github.com/jenpet/traebeler/internal/metrics"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// recordFailure is the failure state of a single record. The ip of the record is the target it failed to be updated
+// to, a new target is attempted right away.
+type recordFailure struct {
+	rec         record
+	attempts    int
+	lastErr     error
+	since       time.Time
+	nextAttempt time.Time
+	quarantined bool
+}
+
+// failureTracker keeps track of records which failed to be updated. Failed records are retried with an exponential
+// backoff instead of on every cycle. Records which failed quarantineAfter times in a row are quarantined and
+// only retried every maxBackoff. A nil failureTracker retries every record on every cycle.
+type failureTracker struct {
+	backoff, maxBackoff time.Duration
+	// quarantineAfter is the number of consecutive failures a record gets quarantined after, 0 to never quarantine
+	quarantineAfter int
+	now             func() time.Time
+	// jitter returns a random duration in [0, n)
+	jitter   func(n int64) int64
+	failures map[string]*recordFailure
+}
+
+func newFailureTracker(backoff, maxBackoff time.Duration, quarantineAfter int) *failureTracker {
+	return &failureTracker{
+		backoff:         backoff,
+		maxBackoff:      maxBackoff,
+		quarantineAfter: quarantineAfter,
+		now:             time.Now,
+		jitter:          rand.Int63n,
+		failures:        map[string]*recordFailure{},
+	}
+}
+
+// due returns the records which may be updated now. Records whose next attempt lies in the future are dropped.
+func (ft *failureTracker) due(recs []record) []record {
+	if ft == nil {
+		return recs
+	}
+	now := ft.now()
+	var due []record
+	for _, rec := range recs {
+		f, ok := ft.failures[registryKey(rec)]
+		if ok && f.rec.ip == rec.ip && now.Before(f.nextAttempt) {
+			log.Debugf("Skipping %s record of domain '%s' until %s after %d failed attempts.", rec.rtype, rec.fqn(), f.nextAttempt.Format(time.RFC3339), f.attempts)
+			continue
+		}
+		due = append(due, rec)
+	}
+	if skipped := len(recs) - len(due); skipped > 0 {
+		log.Infof("Skipping %d records which are waiting for their next attempt.", skipped)
+	}
+	return due
+}
+
+// failed records a failed update of the record and schedules its next attempt.
+func (ft *failureTracker) failed(rec record, err error) {
+	if ft == nil {
+		return
+	}
+	now := ft.now()
+	f, ok := ft.failures[registryKey(rec)]
+	if !ok || f.rec.ip != rec.ip {
+		f = &recordFailure{rec: rec, since: now}
+		ft.failures[registryKey(rec)] = f
+	}
+	f.attempts++
+	f.lastErr = err
+	delay := ft.delay(f.attempts)
+	if ft.quarantineAfter > 0 && f.attempts >= ft.quarantineAfter {
+		if !f.quarantined {
+			log.Errorf("QUARANTINE: %s record of domain '%s' failed %d times in a row since %s and is only retried every %v. Error: %v",
+				rec.rtype, rec.fqn(), f.attempts, f.since.Format(time.RFC3339), ft.maxBackoff, err)
+		}
+		f.quarantined = true
+		delay = ft.maxBackoff
+	}
+	f.nextAttempt = now.Add(delay)
+	log.Infof("%s record of domain '%s' failed %d times in a row, next attempt at %s.", rec.rtype, rec.fqn(), f.attempts, f.nextAttempt.Format(time.RFC3339))
+}
+
+// succeeded drops the failure state of the record.
+func (ft *failureTracker) succeeded(rec record) {
+	if ft == nil {
+		return
+	}
+	if f, ok := ft.failures[registryKey(rec)]; ok {
+		log.Infof("%s record of domain '%s' was updated after %d failed attempts.", rec.rtype, rec.fqn(), f.attempts)
+		delete(ft.failures, registryKey(rec))
+	}
+}
+
+// retain drops the failure state of records of the given type which are not part of the domains anymore.
+func (ft *failureTracker) retain(rtype string, domains []string) {
+	if ft == nil {
+		return
+	}
+	present := toSet(domains)
+	for key, f := range ft.failures {
+		if f.rec.rtype == rtype && !present[f.rec.fqn()] {
+			delete(ft.failures, key)
+		}
+	}
+}
+
+// delay returns the exponential backoff after the given number of attempts with up to 20% jitter.
+func (ft *failureTracker) delay(attempts int) time.Duration {
+	delay := exponentialBackoff(ft.backoff, ft.maxBackoff, attempts)
+	if spread := int64(delay / 5); spread > 0 {
+		delay += time.Duration(ft.jitter(spread))
+	}
+	return delay
+}
+
+// exponentialBackoff returns the backoff after the given number of failed attempts which starts at backoff and doubles
+// with every further attempt up to maxBackoff.
+func exponentialBackoff(backoff, maxBackoff time.Duration, attempts int) time.Duration {
+	delay := backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// pending returns true in case any record is waiting for its next attempt.
+func (ft *failureTracker) pending() bool {
+	return ft != nil && len(ft.failures) > 0
+}
+
+// expose exposes the number of failing and quarantined records as metrics.
+func (ft *failureTracker) expose() {
+	if ft == nil {
+		return
+	}
+	quarantined := 0
+	for _, f := range ft.failures {
+		if f.quarantined {
+			quarantined++
+		}
+	}
+	metrics.SetFailingRecords(processorID, len(ft.failures), quarantined)
+}
+
+// report returns the failing records sorted by their name.
+func (ft *failureTracker) report() []domain.RecordFailure {
+	if ft == nil {
+		return nil
+	}
+	var failures []domain.RecordFailure
+	for _, f := range ft.failures {
+		failures = append(failures, domain.RecordFailure{
+			Record:      fmt.Sprintf("%s (%s)", f.rec.fqn(), f.rec.rtype),
+			Attempts:    f.attempts,
+			LastErr:     f.lastErr.Error(),
+			Since:       f.since,
+			NextAttempt: f.nextAttempt,
+			Quarantined: f.quarantined,
+		})
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Record < failures[j].Record })
+	return failures
+}
@@ -0,0 +1,82 @@
+package froxlor
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFailureTracker_shouldBackOffExponentially(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	ft := newFailureTracker(time.Minute, 5*time.Minute, 0)
+	ft.now = func() time.Time { return now }
+	ft.jitter = func(n int64) int64 { return 0 }
+	rec := record{"foo.bar", "sub", "93.184.216.34", "A"}
+
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		ft.failed(rec, errors.New("froxlor error"))
+		assert.Equal(t, now.Add(expected), ft.failures[registryKey(rec)].nextAttempt)
+	}
+	assert.Equal(t, 5, ft.failures[registryKey(rec)].attempts)
+	assert.False(t, ft.failures[registryKey(rec)].quarantined, "records should never be quarantined without threshold")
+}
+
+func TestFailureTracker_shouldAddJitter(t *testing.T) {
+	ft := newFailureTracker(time.Minute, time.Hour, 0)
+	for i := 0; i < 100; i++ {
+		delay := ft.delay(1)
+		assert.True(t, delay >= time.Minute && delay < time.Minute+12*time.Second, "unexpected delay %v", delay)
+	}
+}
+
+func TestFailureTracker_shouldOnlyReturnDueRecords(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	ft := newFailureTracker(time.Minute, time.Hour, 0)
+	ft.now = func() time.Time { return now }
+	failing := record{"foo.bar", "sub", "93.184.216.34", "A"}
+	healthy := record{"foo.bar", "@", "93.184.216.34", "A"}
+	ft.failed(failing, errors.New("froxlor error"))
+
+	assert.Equal(t, []record{healthy}, ft.due([]record{failing, healthy}), "failed record should wait for its next attempt")
+	moved := failing
+	moved.ip = "93.184.216.35"
+	assert.Equal(t, []record{moved, healthy}, ft.due([]record{moved, healthy}), "a new target should be attempted right away")
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, []record{failing, healthy}, ft.due([]record{failing, healthy}), "failed record should be due after the backoff")
+
+	ft.succeeded(failing)
+	assert.False(t, ft.pending(), "a successful update should drop the failure")
+}
+
+func TestFailureTracker_whenThresholdReached_shouldQuarantine(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	ft := newFailureTracker(time.Second, time.Hour, 3)
+	ft.now = func() time.Time { return now }
+	rec := record{"foo.bar", "sub", "93.184.216.34", "A"}
+	for i := 0; i < 3; i++ {
+		ft.failed(rec, errors.New("froxlor error"))
+	}
+
+	failures := ft.report()
+	assert.Len(t, failures, 1)
+	assert.Equal(t, "sub.foo.bar (A)", failures[0].Record)
+	assert.Equal(t, 3, failures[0].Attempts)
+	assert.Equal(t, "froxlor error", failures[0].LastErr)
+	assert.True(t, failures[0].Quarantined)
+	assert.Equal(t, now.Add(time.Hour), failures[0].NextAttempt, "quarantined records should only be retried after the maximum backoff")
+}
+
+func TestFailureTracker_shouldForgetRemovedDomains(t *testing.T) {
+	ft := newFailureTracker(time.Minute, time.Hour, 0)
+	ft.failed(record{"foo.bar", "sub", "93.184.216.34", "A"}, errors.New("froxlor error"))
+	ft.failed(record{"foo.bar", "sub", "2606:2800:220:1:248:1893:25c8:1946", "AAAA"}, errors.New("froxlor error"))
+	ft.retain("A", []string{"foo.bar"})
+	assert.Len(t, ft.report(), 1, "only the failure of the removed A record should be dropped")
+}
+
+func TestExponentialBackoff_shouldDoubleUpToMaximum(t *testing.T) {
+	assert.Equal(t, time.Minute, exponentialBackoff(time.Minute, time.Hour, 1))
+	assert.Equal(t, 4*time.Minute, exponentialBackoff(time.Minute, time.Hour, 3))
+	assert.Equal(t, time.Hour, exponentialBackoff(time.Minute, time.Hour, 10), "the backoff should be capped")
+}
@@ -1,23 +1,39 @@
 package froxlor
 
 import (
-	"io/ioutil"
-	"net/http"
+	"context"
+	"github.com/jenpet/traebeler/internal/publicip"
+	"time"
 )
 
-const url = "https://api.ipify.org?format=text"
+// detectorApi provides the public addresses detected by the configured IP sources.
+type detectorApi struct {
+	detector *publicip.Detector
+}
 
-type ipifyApi struct {}
+func (api detectorApi) ipv4(ctx context.Context) (string, error) {
+	return api.detector.IPv4(ctx)
+}
 
-func (api ipifyApi) ipv4() (string, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	ip, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	return string(ip), nil
-}
\ No newline at end of file
+func (api detectorApi) ipv6(ctx context.Context) (string, error) {
+	return api.detector.IPv6(ctx)
+}
+
+// addressLoss keeps track of an address which can't be detected anymore. Its records are dropped once and the address
+// is detected again after a backoff of backoff seconds which doubles with every further failure up to maxBackoff
+// seconds, instead of failing every cycle.
+type addressLoss struct {
+	failures int
+	next     time.Time
+}
+
+// failed records a failed detection and delays the next one.
+func (al *addressLoss) failed(backoff, maxBackoff int) {
+	al.failures++
+	al.next = time.Now().Add(exponentialBackoff(time.Duration(backoff)*time.Second, time.Duration(maxBackoff)*time.Second, al.failures))
+}
+
+// pending returns true in case the address was lost and its next detection is not due yet.
+func (al *addressLoss) pending() bool {
+	return al != nil && time.Now().Before(al.next)
+}
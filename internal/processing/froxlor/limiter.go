@@ -0,0 +1,53 @@
+package froxlor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces requests evenly, so no more than the configured number of requests per second are performed.
+// A nil rateLimiter does not limit anything.
+type rateLimiter struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// next is the earliest time the next request may be performed at
+	next time.Time
+}
+
+// newRateLimiter returns a rateLimiter allowing the given number of requests per second, nil in case it is not positive.
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next request may be performed. An error is returned in case the context is done before.
+func (rl *rateLimiter) wait(ctx context.Context) error {
+	if rl == nil {
+		return nil
+	}
+	rl.mu.Lock()
+	now := time.Now()
+	at := rl.next
+	if at.Before(now) {
+		at = now
+	}
+	rl.next = at.Add(rl.interval)
+	rl.mu.Unlock()
+
+	delay := at.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
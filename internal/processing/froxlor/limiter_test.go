@@ -0,0 +1,31 @@
+package froxlor
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_shouldSpaceRequests(t *testing.T) {
+	rl := newRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, rl.wait(context.Background()))
+	}
+	assert.True(t, time.Since(start) >= 40*time.Millisecond, "five requests should take at least four intervals")
+}
+
+func TestRateLimiter_whenContextIsDone_shouldReturnError(t *testing.T) {
+	rl := newRateLimiter(1)
+	assert.Nil(t, rl.wait(context.Background()), "the first request should not wait")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, rl.wait(ctx))
+}
+
+func TestRateLimiter_whenDisabled_shouldNotWait(t *testing.T) {
+	rl := newRateLimiter(0)
+	assert.Nil(t, rl)
+	assert.Nil(t, rl.wait(context.Background()))
+}
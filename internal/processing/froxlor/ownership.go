@@ -0,0 +1,284 @@
+package froxlor
+
+import (
+	"context"
+	"fmt"
+	"github.com/jenpet/traebeler/internal/log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// markerPrefix is the record name prefix of the TXT records marking ownership, e.g. "_traebeler.sub"
+	markerPrefix = "_traebeler"
+	// markerHeritage is the first part of every marker's content identifying traebeler as its creator
+	markerHeritage = "heritage=traebeler"
+)
+
+// ownership states of a record in regards of a txtRegistry
+type ownership int
+
+const (
+	// recordUnowned records do not have any marker, e.g. since they were created manually
+	recordUnowned ownership = iota
+	// recordOwned records are marked with the instance ID of the registry
+	recordOwned
+	// recordForeign records are marked by another traebeler instance
+	recordForeign
+)
+
+// txtRegistry marks every record managed by traebeler with a companion TXT record holding the ID of the traebeler
+// instance, similar to the TXT registry of external-dns. Records are only updated or deleted in case they carry the
+// marker of the instance. Unmarked records are only touched when adoption is enabled.
+type txtRegistry struct {
+	instanceID string
+	adopt      bool
+}
+
+// markerRecord returns the name of the TXT record marking the given record.
+func (tr txtRegistry) markerRecord(rec record) string {
+	if !rec.hasSubdomain() {
+		return markerPrefix
+	}
+	return markerPrefix + "." + rec.subdomain
+}
+
+func (tr txtRegistry) markerContent() string {
+	return fmt.Sprintf("%s,traebeler/instance=%s", markerHeritage, tr.instanceID)
+}
+
+// ownership looks up the TXT markers of a record and returns its ownership state as well as the marker zones.
+func (tr txtRegistry) ownership(ctx context.Context, rh recordHandler, rec record) (ownership, []zone, error) {
+	zones, err := rh.findDomainZones(ctx, rec.tld, tr.markerRecord(rec))
+	if err != nil {
+		return recordUnowned, nil, err
+	}
+	markers := filterZones(zones, "TXT")
+	state := recordUnowned
+	for _, marker := range markers {
+		content := strings.Trim(marker.Content, `"`)
+		if content == tr.markerContent() {
+			return recordOwned, markers, nil
+		}
+		if strings.HasPrefix(content, markerHeritage) {
+			state = recordForeign
+		}
+	}
+	return state, markers, nil
+}
+
+// mark adds the TXT marker of the instance for the given record.
+func (tr txtRegistry) mark(ctx context.Context, rh recordHandler, rec record) error {
+	err := rh.addDomainZone(ctx, rec.tld, tr.markerRecord(rec), tr.markerContent(), fmt.Sprintf("%d", recordTTL), "TXT")
+	if err != nil {
+		return fmt.Errorf("failed adding ownership marker for domain '%s': %v", rec.fqn(), err)
+	}
+	log.Debugf("Marked record of domain '%s' as owned by instance '%s'.", rec.fqn(), tr.instanceID)
+	return nil
+}
+
+// unmark deletes the given TXT markers of a record.
+func (tr txtRegistry) unmark(ctx context.Context, rh recordHandler, rec record, markers []zone) error {
+	for _, marker := range markers {
+		if strings.Trim(marker.Content, `"`) != tr.markerContent() {
+			continue
+		}
+		if err := rh.deleteDomainZone(ctx, rec.tld, marker.ID); err != nil {
+			return fmt.Errorf("failed deleting ownership marker of domain '%s': %v", rec.fqn(), err)
+		}
+	}
+	return nil
+}
+
+// filterZones returns all zones of the given record type.
+func filterZones(zones []zone, rtype string) []zone {
+	var filtered []zone
+	for _, z := range zones {
+		if z.Type == rtype {
+			filtered = append(filtered, z)
+		}
+	}
+	return filtered
+}
+
+// registry keeps track of the zone records and subdomains traebeler created in Froxlor. Only these are proven to be
+// owned by traebeler and are therefore the only ones which may be deleted during garbage collection. The ip of a
+// tracked zone record holds the content which was written.
+type registry struct {
+	mu         sync.Mutex
+	records    map[string]record
+	subdomains map[string]record
+}
+
+func newRegistry() *registry {
+	return &registry{records: map[string]record{}, subdomains: map[string]record{}}
+}
+
+func registryKey(rec record) string {
+	return rec.fqn() + "/" + rec.rtype
+}
+
+func (r *registry) zoneAdded(rec record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records[registryKey(rec)] = rec
+}
+
+func (r *registry) subdomainAdded(rec record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subdomains[rec.fqn()] = record{tld: rec.tld, subdomain: rec.subdomain}
+}
+
+func (r *registry) forget(rec record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.records, registryKey(rec))
+}
+
+func (r *registry) forgetSubdomain(rec record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.subdomains, rec.fqn())
+}
+
+// snapshot returns the owned zone records sorted by their fqn and type and the owned subdomains sorted by their fqn.
+func (r *registry) snapshot() ([]record, []record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var records, subdomains []record
+	for _, owned := range r.records {
+		records = append(records, owned)
+	}
+	for _, owned := range r.subdomains {
+		subdomains = append(subdomains, owned)
+	}
+	sort.Slice(records, func(i, j int) bool { return registryKey(records[i]) < registryKey(records[j]) })
+	sortRecords(subdomains)
+	return records, subdomains
+}
+
+// restore adds the given zone records and subdomains as owned.
+func (r *registry) restore(records, subdomains []record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rec := range records {
+		r.records[registryKey(rec)] = rec
+	}
+	for _, rec := range subdomains {
+		r.subdomains[rec.fqn()] = rec
+	}
+}
+
+// orphans returns all owned zone records of the given type which are not part of the given domains sorted by their fqn.
+func (r *registry) orphans(rtype string, domains []string) []record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	present := toSet(domains)
+	var orphans []record
+	for _, owned := range r.records {
+		if owned.rtype == rtype && !present[owned.fqn()] {
+			orphans = append(orphans, owned)
+		}
+	}
+	sortRecords(orphans)
+	return orphans
+}
+
+// orphanedSubdomains returns all owned subdomains which are not part of the given domains and do not have any owned
+// zone record left, sorted by their fqn.
+func (r *registry) orphanedSubdomains(domains []string) []record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	present := toSet(domains)
+	for _, owned := range r.records {
+		present[owned.fqn()] = true
+	}
+	var orphans []record
+	for fqn, owned := range r.subdomains {
+		if !present[fqn] {
+			orphans = append(orphans, owned)
+		}
+	}
+	sortRecords(orphans)
+	return orphans
+}
+
+func toSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+func sortRecords(recs []record) {
+	sort.Slice(recs, func(i, j int) bool { return recs[i].fqn() < recs[j].fqn() })
+}
+
+// trackingHandler registers every A and AAAA record and subdomain which is successfully added through it as owned.
+type trackingHandler struct {
+	froxlorHandler
+	owned *registry
+}
+
+func (th trackingHandler) addDomainZone(ctx context.Context, domain, rec, content, ttl, rtype string) error {
+	err := th.froxlorHandler.addDomainZone(ctx, domain, rec, content, ttl, rtype)
+	if err == nil && (rtype == typeA || rtype == typeAAAA) {
+		th.owned.zoneAdded(record{tld: domain, subdomain: rec, ip: content, rtype: rtype})
+	}
+	return err
+}
+
+// claim registers a record which is marked as owned by the instance as owned. Records which already point at their
+// target ip are never added through the handler, hence they would otherwise not be collected once their domain is
+// removed.
+func (th trackingHandler) claim(rec record) {
+	th.owned.zoneAdded(rec)
+}
+
+func (th trackingHandler) addDomain(ctx context.Context, domain, subdomain string) error {
+	err := th.froxlorHandler.addDomain(ctx, domain, subdomain)
+	if err == nil {
+		th.owned.subdomainAdded(record{tld: domain, subdomain: subdomain})
+	}
+	return err
+}
+
+// deleteMarkedZone deletes the zone records of the given domain and type in case it is marked as owned. The marker is
+// deleted as well once no address record of the domain is left. The returned bool is false in case no zone record
+// was deleted.
+func (tr txtRegistry) deleteMarkedZone(ctx context.Context, rh recordHandler, rec record) (bool, error) {
+	state, markers, err := tr.ownership(ctx, rh, rec)
+	if err != nil {
+		return false, err
+	}
+	if state != recordOwned {
+		log.Infof("Zone record of removed domain '%s' is not marked as owned by this instance. Leaving it untouched.", rec.fqn())
+		return false, nil
+	}
+	zones, err := rh.findDomainZones(ctx, rec.tld, rec.subdomain)
+	if err != nil {
+		return false, err
+	}
+	owned := filterZones(zones, rec.rtype)
+	for _, zone := range owned {
+		if err := rh.deleteDomainZone(ctx, rec.tld, zone.ID); err != nil {
+			return false, err
+		}
+		if rh.planned() {
+			log.Infof("DRY-RUN: would delete %s record with ID '%s' of removed domain '%s'.", rec.rtype, zone.ID, rec.fqn())
+		} else {
+			log.Infof("Deleted %s record with ID '%s' of removed domain '%s'.", rec.rtype, zone.ID, rec.fqn())
+		}
+	}
+	remaining := typeA
+	if rec.rtype == typeA {
+		remaining = typeAAAA
+	}
+	if len(filterZones(zones, remaining)) > 0 {
+		return len(owned) > 0, nil
+	}
+	return len(owned) > 0, tr.unmark(ctx, rh, rec, markers)
+}
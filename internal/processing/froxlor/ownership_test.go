@@ -0,0 +1,250 @@
+package froxlor
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTrackingHandler_shouldOnlyRegisterSuccessfulAdditions(t *testing.T) {
+	owned := newRegistry()
+	mfh := mockFroxlorHandler{}
+	th := trackingHandler{froxlorHandler: &mfh, owned: owned}
+
+	assert.Nil(t, th.addDomain(context.Background(), "foo.bar", "sub"))
+	assert.Nil(t, th.addDomainZone(context.Background(), "foo.bar", "sub", "127.0.0.1", "18000", "A"))
+	assert.Nil(t, th.addDomainZone(context.Background(), "foo.bar", "sub", "::1", "18000", "AAAA"))
+	assert.Nil(t, th.addDomainZone(context.Background(), "foo.bar", "_traebeler.sub", "heritage=traebeler", "18000", "TXT"))
+	assert.Equal(t, map[string]record{
+		"sub.foo.bar/A":    {"foo.bar", "sub", "127.0.0.1", "A"},
+		"sub.foo.bar/AAAA": {"foo.bar", "sub", "::1", "AAAA"},
+	}, owned.records, "only address records should be owned")
+	assert.Equal(t, map[string]record{"sub.foo.bar": {"foo.bar", "sub", "", ""}}, owned.subdomains)
+
+	mfh.mockRecordHandler.addMock = func(domain, record, content, ttl, rtype string) error {
+		return errors.New("repo error")
+	}
+	assert.NotNil(t, th.addDomainZone(context.Background(), "foo.bar", "failed", "127.0.0.1", "18000", "A"))
+	assert.NotContains(t, owned.records, "failed.foo.bar/A", "failed additions should not be owned")
+}
+
+func TestRegistryOrphans_shouldReturnOwnedRecordsMissingInDomains(t *testing.T) {
+	owned := newRegistry()
+	owned.zoneAdded(record{"foo.bar", "@", "127.0.0.1", "A"})
+	owned.zoneAdded(record{"foo.bar", "b", "127.0.0.1", "A"})
+	owned.zoneAdded(record{"foo.bar", "a", "127.0.0.1", "A"})
+
+	owned.zoneAdded(record{"foo.bar", "a", "::1", "AAAA"})
+
+	orphans := owned.orphans("A", []string{"foo.bar"})
+	assert.Equal(t, []record{
+		{"foo.bar", "a", "127.0.0.1", "A"},
+		{"foo.bar", "b", "127.0.0.1", "A"},
+	}, orphans)
+	assert.Equal(t, []record{{"foo.bar", "a", "::1", "AAAA"}}, owned.orphans("AAAA", []string{"foo.bar"}))
+
+	owned.forget(record{"foo.bar", "a", "127.0.0.1", "A"})
+	assert.Len(t, owned.orphans("A", []string{"foo.bar"}), 1, "forgotten records should not be returned")
+}
+
+func TestRegistryOrphanedSubdomains_shouldOnlyReturnSubdomainsWithoutOwnedRecords(t *testing.T) {
+	owned := newRegistry()
+	owned.subdomainAdded(record{tld: "foo.bar", subdomain: "a"})
+	owned.subdomainAdded(record{tld: "foo.bar", subdomain: "b"})
+	owned.subdomainAdded(record{tld: "foo.bar", subdomain: "c"})
+	owned.zoneAdded(record{"foo.bar", "b", "::1", "AAAA"})
+
+	assert.Equal(t, []record{{"foo.bar", "a", "", ""}}, owned.orphanedSubdomains([]string{"c.foo.bar"}))
+
+	owned.forgetSubdomain(record{tld: "foo.bar", subdomain: "a"})
+	assert.Empty(t, owned.orphanedSubdomains([]string{"c.foo.bar"}), "forgotten subdomains should not be returned")
+}
+
+func TestUpdateRecord_withRegistry_shouldOnlyModifyOwnedRecords(t *testing.T) {
+	reg := &txtRegistry{instanceID: "test"}
+	ownedMarker := zone{"99", "1337", "18000", "_traebeler", "TXT", `"heritage=traebeler,traebeler/instance=test"`}
+	foreignMarker := zone{"99", "1337", "18000", "_traebeler", "TXT", "heritage=traebeler,traebeler/instance=other"}
+	outdated := zone{"98", "1337", "18000", "@", "A", "192.168.178.1"}
+	var registryTests = []struct {
+		name            string
+		adopt           bool
+		zones           []zone
+		markers         []zone
+		expectedAdds    []string
+		expectedDeletes int
+		skipped         bool
+	}{
+		{"new record is marked and added", false, nil, nil, []string{"TXT", "A"}, 0, false},
+		{"owned record is updated", false, []zone{outdated}, []zone{ownedMarker}, []string{"A"}, 1, false},
+		{"unmarked record is left untouched", false, []zone{outdated}, nil, nil, 0, true},
+		{"unmarked record is adopted", true, []zone{outdated}, nil, []string{"TXT", "A"}, 1, false},
+		{"foreign record is left untouched", true, []zone{outdated}, []zone{foreignMarker}, nil, 0, true},
+		{"free record marked by another instance is left untouched", false, nil, []zone{foreignMarker}, nil, 0, true},
+	}
+	for _, tt := range registryTests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg.adopt = tt.adopt
+			var adds []string
+			mrh := mockRecordHandler{
+				findMock: func(domain, record string) ([]zone, error) {
+					if record == "_traebeler" {
+						return tt.markers, nil
+					}
+					return tt.zones, nil
+				},
+				addMock: func(domain, record, content, ttl, rtype string) error {
+					adds = append(adds, rtype)
+					return nil
+				},
+			}
+			updated, err := updateRecord(context.Background(), &mrh, reg, record{"foo.bar", "@", "127.0.0.1", "A"})
+			if tt.skipped {
+				assert.Equal(t, errRecordSkipped, err, "the record should be skipped")
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, record{"foo.bar", "@", "127.0.0.1", "A"}, updated, "the record should be settled")
+			}
+			assert.Equal(t, tt.expectedAdds, adds, "unexpected addDomainZone interactions")
+			assert.Equal(t, tt.expectedDeletes, mrh.deleteInteractions, "unexpected deleteDomainZone interactions")
+		})
+	}
+}
+
+func TestProcess_whenRecordIsNotOwned_shouldNotCacheIt(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	mfh.findMock = func(domain, record string) ([]zone, error) {
+		if record == "_traebeler" {
+			return nil, nil
+		}
+		return []zone{{"98", "1337", "18000", "@", "A", "192.168.178.1"}}, nil
+	}
+	p := Processor{
+		cfg:      config{IPv4: true},
+		api:      &mfh,
+		ip:       mockIpProvider{},
+		owned:    newRegistry(),
+		registry: &txtRegistry{instanceID: "test"},
+		failures: newFailureTracker(time.Minute, time.Hour, 0),
+	}
+
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")), "skipped records should not fail the run")
+	assert.Empty(t, p.cache, "skipped record should not be considered in sync")
+	assert.Empty(t, p.synced)
+	assert.Empty(t, p.Failures(), "skipped record should not be retried with backoff")
+	assert.Equal(t, []string{"foo.bar (A)"}, p.Skipped(), "skipped record should be reported")
+
+	p.registry.adopt = true
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")))
+	assert.Equal(t, []record{{"foo.bar", "@", "127.0.0.1", "A"}}, p.cache, "skipped record should be adopted once adoption is enabled")
+	assert.Empty(t, p.Skipped(), "adopted record should not be reported as skipped anymore")
+}
+
+func TestProcess_whenAdoptedRecordMatchesIP_shouldCollectItOnceRemoved(t *testing.T) {
+	var markers []zone
+	var deletes []string
+	mfh := mockFroxlorHandler{mockRecordHandler: mockRecordHandler{
+		findMock: func(domain, record string) ([]zone, error) {
+			if record == "_traebeler" {
+				return markers, nil
+			}
+			return []zone{{"98", "1337", "18000", "@", "A", "127.0.0.1"}}, nil
+		},
+		addMock: func(domain, record, content, ttl, rtype string) error {
+			if rtype == "TXT" {
+				markers = append(markers, zone{"99", "1337", "18000", record, rtype, content})
+			}
+			return nil
+		},
+		deleteMock: func(domain, entryID string) error {
+			deletes = append(deletes, entryID)
+			return nil
+		},
+	}}
+	owned := newRegistry()
+	p := Processor{
+		cfg:      config{IPv4: true, GarbageCollect: true},
+		api:      trackingHandler{froxlorHandler: &mfh, owned: owned},
+		ip:       mockIpProvider{},
+		owned:    owned,
+		registry: &txtRegistry{instanceID: "test", adopt: true},
+		failures: newFailureTracker(time.Minute, time.Hour, 0),
+	}
+
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")))
+	assert.Len(t, markers, 1, "the adopted record should be marked")
+	assert.Empty(t, deletes, "the adopted record already points at the target ip")
+
+	assert.Nil(t, p.Process(context.Background(), toDomains()))
+	assert.Equal(t, []string{"98", "99"}, deletes, "the adopted record and its marker should be deleted once the domain is removed")
+	assert.Empty(t, owned.records)
+}
+
+func TestTxtRegistryMarkerRecord(t *testing.T) {
+	reg := txtRegistry{instanceID: "test"}
+	assert.Equal(t, "_traebeler", reg.markerRecord(record{"foo.bar", "@", "", "A"}))
+	assert.Equal(t, "_traebeler.sub", reg.markerRecord(record{"foo.bar", "sub", "", "A"}))
+	assert.Equal(t, "heritage=traebeler,traebeler/instance=test", reg.markerContent())
+}
+
+func TestCollectGarbage_withRegistry_shouldOnlyDeleteMarkedRecords(t *testing.T) {
+	reg := &txtRegistry{instanceID: "test"}
+	var gcTests = []struct {
+		name            string
+		markers         []zone
+		expectedDeletes []string
+		// expectedOwned is true in case the record is left untouched and therefore checked again by the next collection
+		expectedOwned bool
+	}{
+		{"marked record and marker are deleted", []zone{{"99", "1337", "18000", "_traebeler.old", "TXT", "heritage=traebeler,traebeler/instance=test"}}, []string{"98", "99"}, false},
+		{"unmarked record is kept", nil, nil, true},
+		{"foreign record is kept", []zone{{"99", "1337", "18000", "_traebeler.old", "TXT", "heritage=traebeler,traebeler/instance=other"}}, nil, true},
+	}
+	for _, tt := range gcTests {
+		t.Run(tt.name, func(t *testing.T) {
+			owned := newRegistry()
+			owned.zoneAdded(record{"foo.bar", "old", "127.0.0.1", "A"})
+			var deletes []string
+			mfh := mockFroxlorHandler{
+				mockRecordHandler: mockRecordHandler{
+					findMock: func(domain, record string) ([]zone, error) {
+						if record == "_traebeler.old" {
+							return tt.markers, nil
+						}
+						// the content was changed in the meantime which does not matter for marked records
+						return []zone{{"98", "1337", "18000", "old", "A", "192.168.178.1"}}, nil
+					},
+					deleteMock: func(domain, entryID string) error {
+						deletes = append(deletes, entryID)
+						return nil
+					},
+				},
+			}
+			assert.Empty(t, collectGarbage(context.Background(), &mfh, owned, reg, "A", []string{}, false))
+			assert.Equal(t, tt.expectedDeletes, deletes, "unexpected deleteDomainZone interactions")
+			assert.Equal(t, tt.expectedOwned, len(owned.records) > 0, "only deleted records should not be owned anymore")
+		})
+	}
+}
+
+func TestDeleteMarkedZone_whenOtherAddressRecordRemains_shouldKeepMarker(t *testing.T) {
+	reg := txtRegistry{instanceID: "test"}
+	var deletes []string
+	mrh := mockRecordHandler{
+		findMock: func(domain, record string) ([]zone, error) {
+			if record == "_traebeler" {
+				return []zone{{"99", "1337", "18000", "_traebeler", "TXT", "heritage=traebeler,traebeler/instance=test"}}, nil
+			}
+			return []zone{{"97", "1337", "18000", "@", "A", "127.0.0.1"}, {"98", "1337", "18000", "@", "AAAA", "::1"}}, nil
+		},
+		deleteMock: func(domain, entryID string) error {
+			deletes = append(deletes, entryID)
+			return nil
+		},
+	}
+	deleted, err := reg.deleteMarkedZone(context.Background(), &mrh, record{"foo.bar", "@", "::1", "AAAA"})
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	assert.Equal(t, []string{"98"}, deletes, "the marker of the remaining A record should be kept")
+}
@@ -5,92 +5,403 @@
 package froxlor
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/bobesa/go-domain-util/domainutil"
+	"github.com/jenpet/traebeler/internal/domain"
 	"github.com/jenpet/traebeler/internal/log"
+	"github.com/jenpet/traebeler/internal/metrics"
+	"github.com/jenpet/traebeler/internal/publicip"
+	"github.com/jenpet/traebeler/internal/state"
+	"github.com/jenpet/traebeler/internal/target"
 	"github.com/kelseyhightower/envconfig"
-	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+// processorID identifies the processor in the configuration and metrics
+const processorID = "froxlor"
+
 // time to live of entries in the repository
 const recordTTL = 18000
 
+// record types of the address records for IPv4 and IPv6
+const (
+	typeA    = "A"
+	typeAAAA = "AAAA"
+)
+
 // Processor which can process domains for Froxlor.
 type Processor struct {
 	cfg   config
 	api   froxlorHandler
 	cache []record
 	ip    ipProvider
+	owned *registry
+	// registry marks managed records in Froxlor, nil in case every record is considered to be managed by traebeler
+	registry *txtRegistry
+	// targets holds the configured addresses of domains which should not point to the detected address
+	targets *target.Resolver
+	// dryRun records the mutating calls instead of performing them, nil in case they should be performed
+	dryRun *dryRunHandler
+	// lastPlan holds the calls planned by the last dry run
+	lastPlan []string
+	// failures delays the next attempt of records which failed to be updated, nil to retry them on every cycle
+	failures *failureTracker
+	// store persists the cache and the owned records across restarts, nil in case they are kept in memory only
+	store state.Store
+	// synced holds the time every cached record was last written or verified in Froxlor by its registry key
+	synced map[string]time.Time
+	// resync expires cached records to verify them against Froxlor, nil in case cached records are never verified
+	resync *resyncer
+	// ipv6Loss is set while the IPv6 address can't be detected, nil in case it is available
+	ipv6Loss *addressLoss
+	// skipped holds the records of every record type which were left untouched in the last update since they are not
+	// owned by the instance
+	skipped map[string][]record
+	// restored is set in case the owned records were restored from the store and no domains were reported since, their
+	// garbage collection is held back until then
+	restored bool
 }
 
-func (p *Processor) Process(domains []string) {
-	log.Infof("Froxlor processor received domains %d (%v)", len(domains), domains)
-	ip, err := p.ip.ipv4()
-	if err != nil {
-		log.Errorf("Failed to get IP v4 address from provider. Error: %v", err)
+// Process registers the given domains in Froxlor. Every enabled address family (A and AAAA records) is processed
+// independently. An error is returned in case the domains could not be processed at all or at least one of the record
+// updates failed. Pending calls towards Froxlor are aborted once the context is done.
+func (p *Processor) Process(ctx context.Context, domains []domain.Domain) error {
+	log.Infof("Froxlor processor received domains %d (%v)", len(domains), domain.Names(domains))
+	return p.process(ctx, domains, p.families())
+}
+
+// ProcessChanges skips the processing in case neither the domains nor the addresses of all enabled address families
+// changed since the last successful run, no failed record is waiting for its next attempt and no cached record has to
+// be verified. Otherwise all domains are processed using the addresses of the change set instead of detecting them
+// once more. The added, removed and unchanged domains are only a signal to skip: the records requiring an update are
+// determined by comparing all domains with the cache, since the cache rather than the last run tells which records are
+// in sync, e.g. after failed or skipped records. Froxlor is only queried for records which are not cached or whose
+// target changed either way.
+func (p *Processor) ProcessChanges(ctx context.Context, changes domain.ChangeSet) error {
+	families := p.families()
+	watched := true
+	for i, family := range families {
+		ip := changes.IPv4
+		if family.family == target.IPv6 {
+			ip = changes.IPv6
+		}
+		if ip == "" {
+			// a lost IPv6 address is not detected before its next detection is due
+			watched = watched && family.family == target.IPv6 && p.ipv6Loss.pending()
+			continue
+		}
+		if family.family == target.IPv6 {
+			p.ipv6Detected(ip)
+		}
+		families[i].detect = func(_ context.Context) (string, error) { return ip, nil }
+	}
+	if watched && changes.Empty() && !p.failures.pending() && !p.resync.due(p.cache) {
+		log.Infof("Froxlor processor skips %d unchanged domains.", len(changes.Domains))
+		return nil
+	}
+	log.Infof("Froxlor processor received domains %d (%d added, %d removed)", len(changes.Domains), len(changes.Added), len(changes.Removed))
+	return p.process(ctx, changes.Domains, families)
+}
+
+func (p *Processor) process(ctx context.Context, domains []domain.Domain, families []addressFamily) error {
+	if p.dryRun != nil {
+		// planned changes are not applied, hence every plan starts with the same cache
+		cache := append([]record{}, p.cache...)
+		defer func() { p.cache = cache }()
+	}
+	var errs []string
+	for _, family := range families {
+		if err := p.processFamily(ctx, domains, family); err != nil {
+			errs = append(errs, fmt.Sprintf("%s records: %v", family.rtype, err))
+		}
+	}
+	if len(domains) > 0 {
+		p.restored = false
+	}
+	if p.dryRun == nil {
+		p.pruneSynced()
+	}
+	p.reportPlan()
+	p.failures.expose()
+	p.saveState()
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// reportPlan logs the calls a dry run would have performed.
+func (p *Processor) reportPlan() {
+	if p.dryRun == nil {
 		return
 	}
-	requiredUpdates, err := p.refreshCache(domains, ip)
+	plan := p.dryRun.plan()
+	p.lastPlan = plan
+	log.Infof("DRY-RUN: Froxlor processor would perform %d calls.", len(plan))
+	for _, step := range plan {
+		log.Infof("DRY-RUN: would %s", step)
+	}
+}
+
+// addressFamily combines the record type of an IP version with the detection of the respective address.
+type addressFamily struct {
+	rtype  string
+	family target.Family
+	detect func(ctx context.Context) (string, error)
+}
+
+// families returns the enabled address families.
+func (p *Processor) families() []addressFamily {
+	var families []addressFamily
+	if p.cfg.IPv4 {
+		families = append(families, addressFamily{rtype: typeA, family: target.IPv4, detect: p.ip.ipv4})
+	}
+	if p.cfg.IPv6 {
+		families = append(families, addressFamily{rtype: typeAAAA, family: target.IPv6, detect: p.ip.ipv6})
+	}
+	return families
+}
+
+// processFamily updates the records of a single address family. Every domain points to its configured target, e.g. the
+// address of its entrypoint, or the detected address which is only looked up in case any domain requires it. In case
+// the IPv6 address can't be detected the connectivity is considered to be gone and all AAAA records owned by traebeler
+// which do not have a configured target are removed, since clients preferring IPv6 would not be able to connect
+// anymore. A failing IPv4 detection on the other hand leaves everything untouched. Only the first failing IPv6
+// detection is returned as an error, the address is detected again with a backoff afterwards, see addressLoss.
+func (p *Processor) processFamily(ctx context.Context, descriptors []domain.Domain, family addressFamily) error {
+	// reported holds all domains, domains only the ones which get a record of the family
+	reported := domain.Names(descriptors)
+	domains := reported
+	targets := map[string]string{}
+	var configured, detected []string
+	for _, d := range descriptors {
+		if ip, ok := p.targets.Resolve(d, family.family); ok {
+			log.Debugf("Domain %v points to configured %s target %s.", d, family.rtype, ip)
+			targets[d.Name] = ip
+			configured = append(configured, d.Name)
+			continue
+		}
+		detected = append(detected, d.Name)
+	}
+	var detectionErr error
+	if len(detected) > 0 && family.rtype == typeAAAA && p.ipv6Loss.pending() {
+		log.Debugf("Skipping detection of the lost IPv6 address until %s.", p.ipv6Loss.next.Format(time.RFC3339))
+		domains = configured
+	} else if len(detected) > 0 {
+		ip, err := family.detect(ctx)
+		switch {
+		case err != nil && family.rtype != typeAAAA:
+			log.Errorf("Failed to get address for %s records from provider. Error: %v", family.rtype, err)
+			return err
+		case err != nil && p.ipv6Loss != nil:
+			p.ipv6Loss.failed(p.cfg.RetryBackoff, p.cfg.RetryBackoffMax)
+			log.Warnf("IPv6 address is still not detectable, detecting it again at %s. Error: %v", p.ipv6Loss.next.Format(time.RFC3339), err)
+			domains = configured
+		case err != nil:
+			log.Errorf("Failed to get address for %s records from provider. Error: %v", family.rtype, err)
+			p.ipv6Loss = &addressLoss{}
+			p.ipv6Loss.failed(p.cfg.RetryBackoff, p.cfg.RetryBackoffMax)
+			p.dropFamily(ctx, family.rtype, configured)
+			domains, detectionErr = configured, err
+		default:
+			if family.rtype == typeAAAA {
+				p.ipv6Detected(ip)
+			}
+			for _, domain := range detected {
+				targets[domain] = ip
+			}
+		}
+	}
+	p.resyncCache(ctx, family.rtype, targets)
+	requiredUpdates, err := p.refreshCache(domains, targets, family.rtype)
 	if err != nil {
 		log.Errorf("Failed to update cache based on domains. Error: %v", err)
-		return
+		return err
+	}
+	log.Infof("Identified %d %s records which require an update", len(requiredUpdates), family.rtype)
+	p.failures.retain(family.rtype, domains)
+	delete(p.skipped, family.rtype)
+	err = p.updateRecordsAndCache(ctx, p.failures.due(requiredUpdates))
+	if p.cfg.GarbageCollect && p.restored && len(reported) == 0 {
+		log.Infof("Holding back garbage collection of restored %s records until domains are reported.", family.rtype)
+	} else if p.cfg.GarbageCollect {
+		if errs := collectGarbage(ctx, p.api, p.owned, p.registry, family.rtype, reported, p.cfg.GarbageCollectSubdomains); len(errs) > 0 {
+			log.Errorf("Multiple (%d) errors occurred during garbage collection. Errors: '%+v'", len(errs), errs)
+			if err == nil {
+				err = fmt.Errorf("%d garbage collections failed", len(errs))
+			}
+		}
 	}
-	log.Infof("Identified %d records which require an update", len(requiredUpdates))
-	p.updateRecordsAndCache(requiredUpdates, ip)
+	if detectionErr != nil {
+		return detectionErr
+	}
+	return err
 }
 
+// ipv6Detected resets a lost IPv6 address once it is detected again.
+func (p *Processor) ipv6Detected(ip string) {
+	if p.ipv6Loss != nil {
+		log.Infof("IPv6 address %s is detectable again after %d failed detections.", ip, p.ipv6Loss.failures)
+		p.ipv6Loss = nil
+	}
+}
+
+// dropFamily removes all owned records of the given type which are not part of the kept domains from Froxlor as well
+// as the cache. Subdomains are kept since the domains are still reported.
+func (p *Processor) dropFamily(ctx context.Context, rtype string, kept []string) {
+	log.Infof("Removing all owned %s records without a configured target since their address is gone.", rtype)
+	if errs := collectGarbage(ctx, p.api, p.owned, p.registry, rtype, kept, false); len(errs) > 0 {
+		log.Errorf("Multiple (%d) errors occurred while removing %s records. Errors: '%+v'", len(errs), rtype, errs)
+	}
+	keep := toSet(kept)
+	cleanedCache := []record{}
+	for _, entry := range p.cache {
+		if entry.rtype != rtype || keep[entry.fqn()] {
+			cleanedCache = append(cleanedCache, entry)
+		}
+	}
+	p.cache = cleanedCache
+}
 
-func (p *Processor) updateRecordsAndCache(recs []record, ip string) {
-	updates, errs := updateRecords(p.api, recs, ip)
+// updateRecordsAndCache updates the given records and caches the updated ones. The next attempt of failed records is
+// delayed, unless they failed since the context is done. Planned updates of a dry run are neither cached nor delayed.
+func (p *Processor) updateRecordsAndCache(ctx context.Context, recs []record) error {
+	updates, skipped, errs := updateRecords(ctx, p.api, p.registry, recs, p.cfg.Concurrency)
 	p.cache = append(p.cache, updates...)
+	p.trackSkipped(skipped)
+	if p.dryRun != nil {
+		return recordErrors(errs, len(recs))
+	}
+	now := time.Now()
+	for _, update := range updates {
+		p.markSynced(update, now)
+		p.failures.succeeded(update)
+	}
+	if ctx.Err() == nil {
+		for _, e := range errs {
+			p.failures.failed(e.rec, e.err)
+		}
+	}
+	return recordErrors(errs, len(recs))
+}
+
+// recordErrors logs the errors of the record updates and summarizes them.
+func recordErrors(errs []recordError, attempted int) error {
 	if len(errs) > 0 {
 		log.Errorf("Multiple (%d) errors occurred during record update. Errors: '%+v'", len(errs), errs)
+		return fmt.Errorf("%d of %d record updates failed", len(errs), attempted)
+	}
+	return nil
+}
+
+// trackSkipped reports the given records as skipped. Skipped records are attempted on every run since they are not
+// cached, hence the skipped records of a record type are reset before its records are updated.
+func (p *Processor) trackSkipped(skipped []record) {
+	if p.skipped == nil {
+		p.skipped = map[string][]record{}
+	}
+	for _, rec := range skipped {
+		p.skipped[rec.rtype] = append(p.skipped[rec.rtype], rec)
 	}
 }
 
-// updateRecords performs multiple async calls towards a record repository to update a given set of records.
-// The returned records array hold the successfully updated records, the errors array potential errors which
-// occurred in one of the updates.
-func updateRecords(fh froxlorHandler, recs []record, ip string) ([]record, []error) {
+// errRecordSkipped is returned for records which are left untouched since they are not owned by the instance. Such
+// records are neither updated nor failed, hence they are not cached and looked up again in the next cycle.
+var errRecordSkipped = errors.New("record is not owned by this instance")
+
+// recordError is the error of a failed record update.
+type recordError struct {
+	rec record
+	err error
+}
+
+func (re recordError) Error() string {
+	return fmt.Sprintf("%s record of '%s': %v", re.rec.rtype, re.rec.fqn(), re.err)
+}
+
+// updateRecords updates a given set of records to their target ip using a pool of at most concurrency workers. The
+// returned records array hold the successfully updated records, the skipped array the records which were left untouched
+// since they are not owned by the instance and the errors array potential errors which occurred in one of the updates.
+func updateRecords(ctx context.Context, fh froxlorHandler, reg *txtRegistry, recs []record, concurrency int) ([]record, []record, []recordError) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(recs) {
+		concurrency = len(recs)
+	}
+	var mu sync.Mutex
+	var errs []recordError
+	var updates, skipped []record
+	jobs := make(chan record)
 	var wg sync.WaitGroup
-	var errs []error
-	var updates []record
-	for _, rec := range recs {
+	for i := 0; i < concurrency; i++ {
 		wg.Add(1)
-		rec := rec
 		go func() {
 			defer wg.Done()
-			if err := ensureDomainExistence(fh, rec); err != nil {
-				log.Errorf("Failed ensuring domain existence of record '%s'. Error: %v", rec.fqn(), err)
-				errs = append(errs, err)
-				return
+			for rec := range jobs {
+				update, err := ensureAndUpdateRecord(ctx, fh, reg, rec)
+				mu.Lock()
+				if err == errRecordSkipped {
+					skipped = append(skipped, rec)
+				} else if err != nil {
+					errs = append(errs, recordError{rec: rec, err: err})
+				} else {
+					updates = append(updates, update)
+				}
+				mu.Unlock()
 			}
-			update, err := updateRecord(fh, rec, ip)
-			if err != nil {
-				errs = append(errs, err)
-				return
-			}
-			updates = append(updates, update)
 		}()
 	}
+	for _, rec := range recs {
+		jobs <- rec
+	}
+	close(jobs)
 	wg.Wait()
-	return updates, errs
+	return updates, skipped, errs
 }
 
-// updateRecord updates a given record in a record repository with a new ip in case it is differing.
+// ensureAndUpdateRecord ensures the existence of the record's domain and updates the record afterwards.
+func ensureAndUpdateRecord(ctx context.Context, fh froxlorHandler, reg *txtRegistry, rec record) (record, error) {
+	if err := ensureDomainExistence(ctx, fh, rec); err != nil {
+		log.Errorf("Failed ensuring domain existence of record '%s'. Error: %v", rec.fqn(), err)
+		recordOperation(fh, metrics.RecordFailed)
+		return record{}, err
+	}
+	update, err := updateRecord(ctx, fh, reg, rec)
+	if err != nil {
+		if err == errRecordSkipped {
+			recordOperation(fh, metrics.RecordSkipped)
+		} else {
+			recordOperation(fh, metrics.RecordFailed)
+		}
+		return record{}, err
+	}
+	return update, nil
+}
+
+// updateRecord updates a given record in a record repository with its target ip in case it is differing.
 // The entry will be looked up first assuming that there will only be one or none result at all. In case the lookup resulted in two
 // records updateRecord will return an error.
 // For a single result the record is deleted first and then re-added. For no result only the addition will be invoked.
 // After a successful update in the repository the new record will be returned which can be used for caching.
 //
+// In case a registry is given an existing entry is only updated when it is marked as owned by the registry's instance.
+// Entries which are unmarked or marked by another instance are left untouched and errRecordSkipped is returned, unless
+// unmarked entries may be adopted. New entries are marked before they are added.
+//
 // In case of any error during repository interactions the functions exits leaving a "dirty" state in the repository and returning an error.
-func updateRecord(rh recordHandler, rec record, ip string) (record, error) {
-	zones, err := rh.findDomainZones(rec.tld, rec.subdomain)
+func updateRecord(ctx context.Context, rh recordHandler, reg *txtRegistry, rec record) (record, error) {
+	ip := rec.ip
+	allZones, err := rh.findDomainZones(ctx, rec.tld, rec.subdomain)
 	if err != nil {
 		return record{}, err
 	}
+	zones := filterZones(allZones, rec.rtype)
 
 	// multiple entries of a record indicate that something went wrong in an earlier update process
 	if len(zones) > 1 {
@@ -98,40 +409,165 @@ func updateRecord(rh recordHandler, rec record, ip string) (record, error) {
 		return record{}, fmt.Errorf("multiple lookup results for existing record entries for domain '%s'", rec.fqn())
 	}
 
+	if reg != nil {
+		// any address record indicates that the name is already in use
+		exists := len(filterZones(allZones, typeA))+len(filterZones(allZones, typeAAAA)) > 0
+		proceed, err := claimRecord(ctx, rh, reg, rec, exists)
+		if err != nil {
+			return record{}, err
+		}
+		if !proceed {
+			return record{}, errRecordSkipped
+		}
+	}
+
 	// if there is an existing entry check the content and deleteDomainZone if it its outdated / not matching
 	if len(zones) == 1 {
 		// same ip as the given one
 		entry := zones[0]
 		if entry.Content == ip {
 			rec.ip = ip
+			if reg != nil {
+				// the record carries the instance's marker now without being added, e.g. since it was adopted
+				rh.claim(rec)
+			}
 			log.Infof("Present record %+v matches ip of entry with ID '%s' and domain ID '%s' for domain '%s'. No update required.", rec, entry.ID, entry.DomainID, rec.fqn())
 			return rec, nil
 		}
 
 		// ip in the api is different than the passed one so deleteDomainZone the api entry and create a new one
-		err = rh.deleteDomainZone(rec.tld, entry.ID)
+		err = rh.deleteDomainZone(ctx, rec.tld, entry.ID)
 		if err != nil {
 			log.Errorf("Failed to deleteDomainZone record entry with ID '%s' for domain '%s'. Error: %s", entry.ID, rec.fqn(), err)
 			return record{}, err
 		}
 	}
 
-	err = rh.addDomainZone(rec.tld, rec.subdomain, ip, fmt.Sprintf("%d", recordTTL), "A")
+	err = rh.addDomainZone(ctx, rec.tld, rec.subdomain, ip, fmt.Sprintf("%d", recordTTL), rec.rtype)
 	if err != nil {
 		log.Errorf("Failed to addDomainZone record for domain '%s' with ip '%s'. Error: %s", rec.fqn(), ip, err)
 		return record{}, err
 	}
+	if rh.planned() {
+		log.Infof("DRY-RUN: would update ip for domain '%s' to '%s' in repository.", rec.fqn(), ip)
+		rec.ip = ip
+		return rec, nil
+	}
+	if len(zones) == 1 {
+		recordOperation(rh, metrics.RecordUpdated)
+	} else {
+		recordOperation(rh, metrics.RecordCreated)
+	}
 	log.Infof("Updated ip for domain '%s' to '%s' in repository.", rec.fqn(), ip)
 	rec.ip = ip
 	return rec, nil
 }
 
+// claimRecord ensures that a record is owned by the registry's instance before it is modified. Unmarked records are
+// claimed in case they do not exist yet or adoption is enabled. The returned bool is false in case the record must
+// not be modified.
+func claimRecord(ctx context.Context, rh recordHandler, reg *txtRegistry, rec record, exists bool) (bool, error) {
+	state, _, err := reg.ownership(ctx, rh, rec)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case state == recordOwned:
+		return true, nil
+	case state == recordForeign:
+		log.Warnf("Record of domain '%s' is owned by another traebeler instance. Leaving it untouched.", rec.fqn())
+		return false, nil
+	case exists && !reg.adopt:
+		log.Warnf("Record of domain '%s' was not created by traebeler and adoption is disabled. Leaving it untouched.", rec.fqn())
+		return false, nil
+	}
+	if exists {
+		log.Infof("Adopting unmarked record of domain '%s'.", rec.fqn())
+	}
+	return true, reg.mark(ctx, rh, rec)
+}
+
+// collectGarbage deletes the owned zone records of the given type which are not part of the given domains anymore.
+// Without a TXT registry a zone record is only deleted in case its type and content still match the ones written by
+// traebeler, otherwise it was changed outside of traebeler and is left untouched. With a TXT registry the zone record
+// has to carry the instance's marker instead. Subdomains created by traebeler are deleted in case it is requested and
+// no owned zone record is left for them. Records which were deleted are dropped from the registry, records which were
+// left untouched are kept and checked again during the next collection.
+func collectGarbage(ctx context.Context, fh froxlorHandler, owned *registry, reg *txtRegistry, rtype string, domains []string, subdomains bool) []error {
+	var errs []error
+	for _, orphan := range owned.orphans(rtype, domains) {
+		deleteZone := deleteOwnedZone
+		if reg != nil {
+			deleteZone = reg.deleteMarkedZone
+		}
+		deleted, err := deleteZone(ctx, fh, orphan)
+		if err != nil {
+			log.Errorf("Failed to delete %s record of removed domain '%s'. Error: %v", orphan.rtype, orphan.fqn(), err)
+			recordOperation(fh, metrics.RecordFailed)
+			errs = append(errs, err)
+			continue
+		}
+		if !deleted || fh.planned() {
+			continue
+		}
+		recordOperation(fh, metrics.RecordDeleted)
+		owned.forget(orphan)
+	}
+	if !subdomains {
+		return errs
+	}
+	for _, orphan := range owned.orphanedSubdomains(domains) {
+		if err := fh.deleteDomain(ctx, orphan.fqn()); err != nil {
+			log.Errorf("Failed to delete subdomain of removed domain '%s'. Error: %v", orphan.fqn(), err)
+			recordOperation(fh, metrics.RecordFailed)
+			errs = append(errs, err)
+			continue
+		}
+		if fh.planned() {
+			log.Infof("DRY-RUN: would delete subdomain '%s' since its domain was removed.", orphan.fqn())
+			continue
+		}
+		recordOperation(fh, metrics.RecordDeleted)
+		log.Infof("Deleted subdomain '%s' since its domain was removed.", orphan.fqn())
+		owned.forgetSubdomain(orphan)
+	}
+	return errs
+}
+
+// deleteOwnedZone deletes the zone record of the given domain which still holds the content written by traebeler. The
+// returned bool is false in case no zone record was deleted.
+func deleteOwnedZone(ctx context.Context, rh recordHandler, rec record) (bool, error) {
+	if rec.ip == "" {
+		return false, nil
+	}
+	zones, err := rh.findDomainZones(ctx, rec.tld, rec.subdomain)
+	if err != nil {
+		return false, err
+	}
+	for _, zone := range zones {
+		if zone.Type != rec.rtype || zone.Content != rec.ip {
+			continue
+		}
+		if err := rh.deleteDomainZone(ctx, rec.tld, zone.ID); err != nil {
+			return false, err
+		}
+		if rh.planned() {
+			log.Infof("DRY-RUN: would delete zone record with ID '%s' of removed domain '%s'.", zone.ID, rec.fqn())
+			return true, nil
+		}
+		log.Infof("Deleted zone record with ID '%s' of removed domain '%s'.", zone.ID, rec.fqn())
+		return true, nil
+	}
+	log.Infof("Zone record of removed domain '%s' with ip '%s' is gone or was changed outside of traebeler. Leaving it untouched.", rec.fqn(), rec.ip)
+	return false, nil
+}
+
 // ensureDomainExistence ensures that a record exists in within froxlor for the customer.
 //
 // Since traebeler only operates on the behalf of a customer we can just ensure subdomains.
 // A missing "main" domain has to be registered by an admin manually and will result in an error.
-func ensureDomainExistence(dh domainHandler, rec record) error {
-	exists, err := dh.domainExists(rec.fqn())
+func ensureDomainExistence(ctx context.Context, dh domainHandler, rec record) error {
+	exists, err := dh.domainExists(ctx, rec.fqn())
 	if err != nil {
 		return err
 	}
@@ -141,12 +577,41 @@ func ensureDomainExistence(dh domainHandler, rec record) error {
 	if !rec.hasSubdomain() {
 		return errors.New("record does not have a subdomain that can be used for creation")
 	}
-	return dh.addDomain(rec.tld, rec.subdomain)
+	return dh.addDomain(ctx, rec.tld, rec.subdomain)
 }
 
 // ID returns the identifier for the froxlor processor
 func (p *Processor) ID() string {
-	return "froxlor"
+	return processorID
+}
+
+// EnableDryRun makes the processor report its planned calls instead of performing them. It has to be enabled before
+// the processor is initialized.
+func (p *Processor) EnableDryRun() {
+	p.dryRun = &dryRunHandler{}
+}
+
+// Failures returns the records which failed to be updated and are waiting for their next attempt.
+func (p *Processor) Failures() []domain.RecordFailure {
+	return p.failures.report()
+}
+
+// Skipped returns the records which were left untouched in the last run since they are not owned by the instance,
+// sorted by their name.
+func (p *Processor) Skipped() []string {
+	var skipped []string
+	for _, recs := range p.skipped {
+		for _, rec := range recs {
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", rec.fqn(), rec.rtype))
+		}
+	}
+	sort.Strings(skipped)
+	return skipped
+}
+
+// Plan returns the calls the last dry run would have performed.
+func (p *Processor) Plan() []string {
+	return p.lastPlan
 }
 
 func (p *Processor) Init() error {
@@ -157,16 +622,83 @@ func (p *Processor) Init() error {
 	if p.cache == nil {
 		p.cache = []record{}
 	}
-	p.api = froxlorApi{uri: p.cfg.URI, key: p.cfg.Key, secret: p.cfg.Secret, action: http.Post}
-	p.ip = ipifyApi{}
+	if p.owned == nil {
+		p.owned = newRegistry()
+	}
+	switch p.cfg.Registry {
+	case "txt":
+		p.registry = &txtRegistry{instanceID: p.cfg.InstanceID, adopt: p.cfg.AdoptUnowned}
+	case "none":
+		p.registry = nil
+	default:
+		return fmt.Errorf("TRAEBELER_PROCESSOR_FROXLOR_REGISTRY names an unknown registry '%s', expected 'txt' or 'none'", p.cfg.Registry)
+	}
+	if p.cfg.Concurrency < 1 {
+		return fmt.Errorf("TRAEBELER_PROCESSOR_FROXLOR_CONCURRENCY has to be greater than 0 but is %d", p.cfg.Concurrency)
+	}
+	if p.cfg.RequestsPerSecond < 0 {
+		return fmt.Errorf("TRAEBELER_PROCESSOR_FROXLOR_REQUESTS_PER_SECOND must not be negative but is %v", p.cfg.RequestsPerSecond)
+	}
+	if p.cfg.RequestRetries < 0 {
+		return fmt.Errorf("TRAEBELER_PROCESSOR_FROXLOR_REQUEST_RETRIES must not be negative but is %d", p.cfg.RequestRetries)
+	}
+	if p.cfg.QuarantineAfter < 0 {
+		return fmt.Errorf("TRAEBELER_PROCESSOR_FROXLOR_QUARANTINE_AFTER must not be negative but is %d", p.cfg.QuarantineAfter)
+	}
+	if p.cfg.RetryBackoff <= 0 {
+		return fmt.Errorf("TRAEBELER_PROCESSOR_FROXLOR_RETRY_BACKOFF has to be greater than 0 but is %d", p.cfg.RetryBackoff)
+	}
+	if p.cfg.RetryBackoffMax < p.cfg.RetryBackoff {
+		return fmt.Errorf("TRAEBELER_PROCESSOR_FROXLOR_RETRY_BACKOFF_MAX has to be at least the retry backoff of %d but is %d", p.cfg.RetryBackoff, p.cfg.RetryBackoffMax)
+	}
+	if p.cfg.ResyncInterval < 0 {
+		return fmt.Errorf("TRAEBELER_PROCESSOR_FROXLOR_RESYNC_INTERVAL must not be negative but is %d", p.cfg.ResyncInterval)
+	}
+	if p.cfg.ResyncJitter < 0 || p.cfg.ResyncJitter > 100 {
+		return fmt.Errorf("TRAEBELER_PROCESSOR_FROXLOR_RESYNC_JITTER has to be between 0 and 100 but is %d", p.cfg.ResyncJitter)
+	}
+	p.resync = newResyncer(time.Duration(p.cfg.ResyncInterval)*time.Second, p.cfg.ResyncJitter)
+	p.failures = newFailureTracker(time.Duration(p.cfg.RetryBackoff)*time.Second, time.Duration(p.cfg.RetryBackoffMax)*time.Second, p.cfg.QuarantineAfter)
+	var api froxlorHandler = froxlorApi{
+		uri:     p.cfg.URI,
+		key:     p.cfg.Key,
+		secret:  p.cfg.Secret,
+		action:  httpPost,
+		limiter: newRateLimiter(p.cfg.RequestsPerSecond),
+		retries: p.cfg.RequestRetries,
+	}
+	p.api = trackingHandler{froxlorHandler: api, owned: p.owned}
+	if p.dryRun != nil {
+		// planned additions must not be owned
+		p.dryRun.froxlorHandler = api
+		p.api = p.dryRun
+	}
+	detector, err := publicip.NewDetector()
+	if err != nil {
+		return err
+	}
+	p.ip = detectorApi{detector: detector}
+	if p.targets, err = target.NewResolver(); err != nil {
+		return err
+	}
+	if p.store, err = state.NewStore(processorID); err != nil {
+		return err
+	}
+	p.loadState()
 	return nil
 }
 
-// drops old cache entries which are not part of the domains array and returns new records which where not in the
-// cache or require an update since the ip changed
-func (p *Processor) refreshCache(domains []string, ip string) ([]record, error) {
+// drops old cache entries of the given record type which are not part of the domains array and returns new records
+// which where not in the cache or require an update since their target ip changed. The returned records hold their
+// target ip. Entries of other record types are kept.
+func (p *Processor) refreshCache(domains []string, targets map[string]string, rtype string) ([]record, error) {
 	cleanedCache := []record{}
 	updateRequired := []record{}
+	for _, entry := range p.cache {
+		if entry.rtype != rtype {
+			cleanedCache = append(cleanedCache, entry)
+		}
+	}
 
 	// check every new incoming domain
 	for _, domain := range domains {
@@ -175,11 +707,13 @@ func (p *Processor) refreshCache(domains []string, ip string) ([]record, error)
 			log.Errorf("Failed converting domain string to record. Error %+v", err)
 			return []record{}, err
 		}
+		rec.rtype = rtype
+		rec.ip = targets[domain]
 		requiresUpdate := true
 		// search for the entry in the cache and whether the ip changed
 		for _, entry := range p.cache {
 			// if nothing changed addDomainZone them to the cleaned up cache
-			if domain == entry.fqn() && entry.ip == ip {
+			if domain == entry.fqn() && entry.rtype == rtype && entry.ip == rec.ip {
 				cleanedCache = append(cleanedCache, entry)
 				requiresUpdate = false
 				break
@@ -219,6 +753,8 @@ type record struct {
 	tld       string
 	subdomain string
 	ip        string
+	// rtype is the type of the address record, either A or AAAA
+	rtype string
 }
 
 func (r record) fqn() string {
@@ -238,22 +774,58 @@ type froxlorHandler interface {
 }
 
 type recordHandler interface {
-	findDomainZones(domain, record string) ([]zone, error)
-	addDomainZone(domain, record, content, ttl, rtype string) error
-	deleteDomainZone(domain, entryID string) error
+	findDomainZones(ctx context.Context, domain, record string) ([]zone, error)
+	addDomainZone(ctx context.Context, domain, record, content, ttl, rtype string) error
+	deleteDomainZone(ctx context.Context, domain, entryID string) error
+	// claim takes note of a record which is marked as owned by the instance without being added, see trackingHandler
+	claim(rec record)
+	// planned returns true in case mutating calls are only planned instead of performed, see dryRunHandler
+	planned() bool
 }
 
 type domainHandler interface {
-	domainExists(fqn string) (bool, error)
-	addDomain(domain, subdomain string) error
+	domainExists(ctx context.Context, fqn string) (bool, error)
+	addDomain(ctx context.Context, domain, subdomain string) error
+	deleteDomain(ctx context.Context, fqn string) error
 }
 
 type ipProvider interface {
-	ipv4() (string, error)
+	ipv4(ctx context.Context) (string, error)
+	ipv6(ctx context.Context) (string, error)
 }
 
 type config struct {
 	URI string
 	Key string
 	Secret string
+	// GarbageCollect deletes the zone records traebeler created for domains which are not reported anymore
+	GarbageCollect bool `split_words:"true"`
+	// GarbageCollectSubdomains additionally deletes the subdomains traebeler created for these domains
+	GarbageCollectSubdomains bool `split_words:"true"`
+	// Registry defines how ownership of records is tracked in Froxlor, either "txt" or "none"
+	Registry string `default:"none"`
+	// InstanceID identifies this traebeler instance in the ownership markers
+	InstanceID string `split_words:"true" default:"default"`
+	// AdoptUnowned allows traebeler to take over existing records which do not carry any ownership marker
+	AdoptUnowned bool `split_words:"true"`
+	// IPv4 and IPv6 switch the management of A and AAAA records on or off
+	IPv4 bool `envconfig:"ipv4" default:"true"`
+	IPv6 bool `envconfig:"ipv6" default:"false"`
+	// Concurrency is the maximum number of records which are updated at the same time
+	Concurrency int `default:"4"`
+	// RequestsPerSecond limits the requests towards the Froxlor API, 0 for no limit
+	RequestsPerSecond float64 `split_words:"true" default:"10"`
+	// RequestRetries is the number of times a request failing due to a transient error is retried right away
+	RequestRetries int `split_words:"true" default:"2"`
+	// RetryBackoff is the time in seconds a failed record is retried after, doubled with every further failure up to
+	// RetryBackoffMax
+	RetryBackoff    int `split_words:"true" default:"30"`
+	RetryBackoffMax int `split_words:"true" default:"3600"`
+	// QuarantineAfter is the number of consecutive failures a record is quarantined after, 0 to never quarantine
+	QuarantineAfter int `split_words:"true" default:"10"`
+	// ResyncInterval is the time in seconds after which cached records are verified against Froxlor, 0 to never
+	// verify them
+	ResyncInterval int `split_words:"true" default:"0"`
+	// ResyncJitter is the percentage of the interval a record is verified earlier at most, chosen at random per record
+	ResyncJitter int `split_words:"true" default:"0"`
 }
\ No newline at end of file
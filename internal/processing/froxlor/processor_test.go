@@ -1,9 +1,16 @@
 package froxlor
 
 import (
+	"context"
 	"errors"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/jenpet/traebeler/internal/metrics"
+	"github.com/jenpet/traebeler/internal/target"
+	"github.com/jenpet/traebeler/internal/test"
 	"github.com/stretchr/testify/assert"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRefreshCache_shouldKeepValidEntriesAndReturnAListOfRequiredUpdates(t *testing.T) {
@@ -20,30 +27,30 @@ func TestRefreshCache_shouldKeepValidEntriesAndReturnAListOfRequiredUpdates(t *t
 			[]record{},
 			[]string{"foo.bar", "sub.jen.pet"},
 			[]record{},
-			[]record{{"foo.bar", "@", ""}, {"jen.pet", "sub", ""}},
+			[]record{{"foo.bar", "@", "127.0.0.1", "A"}, {"jen.pet", "sub", "127.0.0.1", "A"}},
 			false,
 		},
 		{
 			"incomplete cache requires some to be updated",
-			[]record{{"foo.bar", "@", "127.0.0.1"}, {"jen.pet", "old", "127.0.0.1"}},
+			[]record{{"foo.bar", "@", "127.0.0.1", "A"}, {"jen.pet", "old", "127.0.0.1", "A"}},
 			[]string{"foo.bar", "new.foo.bar"},
-			[]record{{"foo.bar", "@", "127.0.0.1"}},
-			[]record{{"foo.bar", "new", ""}},
+			[]record{{"foo.bar", "@", "127.0.0.1", "A"}},
+			[]record{{"foo.bar", "new", "127.0.0.1", "A"}},
 			false,
 		},
 		{
 			"changed ip requires update",
-			[]record{{"foo.bar", "@", "192.168.178.1"}},
+			[]record{{"foo.bar", "@", "192.168.178.1", "A"}},
 			[]string{"foo.bar"},
 			[]record{},
-			[]record{{"foo.bar", "@", ""}},
+			[]record{{"foo.bar", "@", "127.0.0.1", "A"}},
 			false,
 		},
 		{
 			"malformed domains should result in error",
-			[]record{{"foo.bar", "@", "192.168.178.1"}},
+			[]record{{"foo.bar", "@", "192.168.178.1", "A"}},
 			[]string{"foo--"},
-			[]record{{"foo.bar", "@", "192.168.178.1"}},
+			[]record{{"foo.bar", "@", "192.168.178.1", "A"}},
 			[]record{},
 			true,
 		},
@@ -53,7 +60,11 @@ func TestRefreshCache_shouldKeepValidEntriesAndReturnAListOfRequiredUpdates(t *t
 		t.Run(tt.name, func(t *testing.T) {
 			p := Processor{cache: tt.initialCache}
 			// use hardcoded ip an vary the given cache
-			ru, err := p.refreshCache(tt.domains, "127.0.0.1")
+			targets := map[string]string{}
+			for _, domain := range tt.domains {
+				targets[domain] = "127.0.0.1"
+			}
+			ru, err := p.refreshCache(tt.domains, targets, "A")
 			assert.Equal(t, tt.errorExpected, err != nil, "error expected: '%t' and received '%t'", tt.errorExpected, err != nil)
 			assert.ElementsMatch(t, tt.expectedCache, p.cache, "expected and actual cache did not match")
 			assert.ElementsMatch(t, tt.expectedRequiredUpdates, ru, "expected required updates and actual returned list did not match")
@@ -62,44 +73,44 @@ func TestRefreshCache_shouldKeepValidEntriesAndReturnAListOfRequiredUpdates(t *t
 }
 
 func TestUpdateRecord_whenRepositoryReturnsSingleValueHavingSameIP_shouldReturnRecord(t *testing.T) {
-	rec := record{"foo.bar", "@", ""}
+	rec := record{"foo.bar", "@", "127.0.0.1", "A"}
 	mrh := mockRecordHandler{
 		findMock: func(domain, record string) ([]zone, error) {
 			return []zone{{"98", "1337", "18000", "@", "A", "127.0.0.1"}}, nil
 		},
 	}
-	updated, err := updateRecord(&mrh, rec, "127.0.0.1")
+	updated, err := updateRecord(context.Background(), &mrh, nil, rec)
 	assert.Nil(t, err, "no error should occur when working on a single valid record")
-	assert.Equal(t, record{"foo.bar", "@", "127.0.0.1"}, updated, "record should be updated the values retrieved from the api")
+	assert.Equal(t, record{"foo.bar", "@", "127.0.0.1", "A"}, updated, "record should be updated the values retrieved from the api")
 	assert.Equal(t, 1, mrh.findInteractions, "expected only one findDomainZones interaction")
 	assert.Equal(t, 0, mrh.deleteInteractions, "expected exactly one deleteDomainZone interaction")
 	assert.Equal(t, 0, mrh.addInteractions, "expected exactly one addDomainZone interaction")
 }
 
 func TestUpdateRecord_whenRepositoryReturnsSingleValueHavingDifferentIP_shouldUpdateRecordInRepoAndReturnUpdated(t *testing.T) {
-	rec := record{"foo.bar", "@", ""}
+	rec := record{"foo.bar", "@", "127.0.0.1", "A"}
 	mrh := mockRecordHandler{
 		findMock: func(domain, record string) ([]zone, error) {
 			return []zone{{"98", "1337", "18000", "@", "A", "192.168.178.1"}}, nil
 		},
 	}
-	updated, err := updateRecord(&mrh, rec, "127.0.0.1")
+	updated, err := updateRecord(context.Background(), &mrh, nil, rec)
 	assert.Nil(t, err, "no error should occur when working on a single record and updating its value")
-	assert.Equal(t, record{"foo.bar", "@", "127.0.0.1"}, updated, "record should be updated when the api contains a mismatch")
+	assert.Equal(t, record{"foo.bar", "@", "127.0.0.1", "A"}, updated, "record should be updated when the api contains a mismatch")
 	assert.Equal(t, 1, mrh.findInteractions, "expected exactly one findDomainZones interaction")
 	assert.Equal(t, 1, mrh.deleteInteractions, "expected exactly one deleteDomainZone interaction")
 	assert.Equal(t, 1, mrh.addInteractions, "expected exactly one addDomainZone interaction")
 }
 
 func TestUpdateRecord_whenRepositoryReturnsMultipleValues_shouldReturnErrorAndPerformNothing(t *testing.T) {
-	rec := record{"foo.bar", "@", ""}
+	rec := record{"foo.bar", "@", "127.0.0.1", "A"}
 	mrh := mockRecordHandler{
 		findMock: func(domain, record string) ([]zone, error) {
 			return []zone{{"98", "1337", "18000", "@", "A", "192.168.178.1"},
 				{"98", "1338", "18000", "@", "A", "127.0.0.1"}}, nil
 		},
 	}
-	updated, err := updateRecord(&mrh, rec, "127.0.0.1")
+	updated, err := updateRecord(context.Background(), &mrh, nil, rec)
 	assert.NotNil(t, err, "an error should occur when multiple results are returned by the repository during lookup")
 	assert.Equal(t, record{}, updated, "returned record should be blank when having multiple results during lookup")
 	assert.Equal(t, 1, mrh.findInteractions, "expected exactly one findDomainZones interaction")
@@ -108,22 +119,22 @@ func TestUpdateRecord_whenRepositoryReturnsMultipleValues_shouldReturnErrorAndPe
 }
 
 func TestUpdateRecord_whenRepositoryReturnsNoValue_shouldAddRecordAndReturnUpdate(t *testing.T) {
-	rec := record{"foo.bar", "@", ""}
+	rec := record{"foo.bar", "@", "127.0.0.1", "A"}
 	mrh := mockRecordHandler{
 		findMock: func(domain, record string) ([]zone, error) {
 			return []zone{}, nil
 		},
 	}
-	updated, err := updateRecord(&mrh, rec, "127.0.0.1")
+	updated, err := updateRecord(context.Background(), &mrh, nil, rec)
 	assert.Nil(t, err, "no error should occur when api does not have an entry")
-	assert.Equal(t, record{"foo.bar", "@", "127.0.0.1"}, updated, "record should be updated when the api contains no value at all")
+	assert.Equal(t, record{"foo.bar", "@", "127.0.0.1", "A"}, updated, "record should be updated when the api contains no value at all")
 	assert.Equal(t, 1, mrh.findInteractions, "expected exactly one findDomainZones interaction")
 	assert.Equal(t, 0, mrh.deleteInteractions, "expected exactly one deleteDomainZone interaction")
 	assert.Equal(t, 1, mrh.addInteractions, "expected exactly one addDomainZone interaction")
 }
 
 func TestUpdateRecords_whenPartiallyFails_shouldReturnUpdatesAndErrors(t *testing.T) {
-	recs := []record{{"foo.bar", "@", ""}, {"foo.bar", "sub", ""}, {"example.com", "@", ""}}
+	recs := []record{{"foo.bar", "@", "127.0.0.1", "A"}, {"foo.bar", "sub", "127.0.0.1", "A"}, {"example.com", "@", "127.0.0.1", "A"}}
 	mrh := mockRecordHandler{
 		findMock: func(domain, record string) ([]zone, error) {
 			if record == "sub" {
@@ -142,29 +153,110 @@ func TestUpdateRecords_whenPartiallyFails_shouldReturnUpdatesAndErrors(t *testin
 		mockRecordHandler: mrh,
 		mockDomainHandler: mdh,
 	}
-	updates, errs := updateRecords(&mfh, recs, "127.0.0.1")
+	updates, _, errs := updateRecords(context.Background(), &mfh, nil, recs, 2)
 	assert.Len(t, updates, 1, "at least one update should succeed")
 	assert.Len(t, errs, 2, "at least two updates should fail")
-	assert.Equal(t, record{"foo.bar", "@", "127.0.0.1"}, updates[0], "at least one update should be returned")
+	assert.Equal(t, record{"foo.bar", "@", "127.0.0.1", "A"}, updates[0], "at least one update should be returned")
 	assert.Equal(t, 2, mfh.findInteractions, "expected two findDomainZones interactions")
 	assert.Equal(t, 1, mfh.addInteractions, "expected exactly one addDomainZone interaction")
 }
 
+func TestUpdateRecords_shouldNotExceedConcurrency(t *testing.T) {
+	var recs []record
+	for _, sub := range []string{"a", "b", "c", "d", "e", "f"} {
+		recs = append(recs, record{"foo.bar", sub, "127.0.0.1", "A"})
+	}
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	mfh := mockFroxlorHandler{mockRecordHandler: mockRecordHandler{
+		findMock: func(domain, record string) ([]zone, error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return []zone{}, nil
+		},
+	}}
+	updates, _, errs := updateRecords(context.Background(), &mfh, nil, recs, 2)
+	assert.Len(t, updates, 6, "every record should be updated")
+	assert.Empty(t, errs)
+	assert.Equal(t, 2, maxInFlight, "no more than two records should be updated at the same time")
+}
+
 func TestProcess_shouldEventuallyUpdateRepositoryAndCache(t *testing.T) {
 	mfh := mockFroxlorHandler{}
 	p := Processor{}
-	recs := []record{{"foo.bar", "@", "127.0.0.1"}, {"foo.bar", "sub", "127.0.0.1"}}
+	recs := []record{{"foo.bar", "@", "127.0.0.1", "A"}, {"foo.bar", "sub", "127.0.0.1", "A"}}
 	assert.Nil(t, p.Init(), "initializing the processor should not result in an error")
 	// actively overwrite the repository to not use traefik
 	p.api = &mfh
 	p.ip = mockIpProvider{}
 
-	p.Process([]string{"foo.bar", "sub.foo.bar"})
+	p.Process(context.Background(), toDomains("foo.bar", "sub.foo.bar"))
 	assert.Equal(t, 2, mfh.findInteractions, "expected two findDomainZones interactions")
 	assert.Equal(t, 2, mfh.addInteractions, "expected two addDomainZone interactions")
 	assert.ElementsMatch(t, p.cache, recs, "expected elements in cache are invalid")
 }
 
+func TestInit_whenOptionIsOutOfRange_shouldNameIt(t *testing.T) {
+	initTests := []struct {
+		name     string
+		vars     map[string]string
+		expected string
+	}{
+		{"unknown registry", map[string]string{"TRAEBELER_PROCESSOR_FROXLOR_REGISTRY": "foo"}, "TRAEBELER_PROCESSOR_FROXLOR_REGISTRY names an unknown registry 'foo', expected 'txt' or 'none'"},
+		{"concurrency leq zero", map[string]string{"TRAEBELER_PROCESSOR_FROXLOR_CONCURRENCY": "0"}, "TRAEBELER_PROCESSOR_FROXLOR_CONCURRENCY has to be greater than 0 but is 0"},
+		{"negative requests per second", map[string]string{"TRAEBELER_PROCESSOR_FROXLOR_REQUESTS_PER_SECOND": "-0.5"}, "TRAEBELER_PROCESSOR_FROXLOR_REQUESTS_PER_SECOND must not be negative but is -0.5"},
+		{"negative request retries", map[string]string{"TRAEBELER_PROCESSOR_FROXLOR_REQUEST_RETRIES": "-1"}, "TRAEBELER_PROCESSOR_FROXLOR_REQUEST_RETRIES must not be negative but is -1"},
+		{"negative quarantine threshold", map[string]string{"TRAEBELER_PROCESSOR_FROXLOR_QUARANTINE_AFTER": "-1"}, "TRAEBELER_PROCESSOR_FROXLOR_QUARANTINE_AFTER must not be negative but is -1"},
+		{"retry backoff leq zero", map[string]string{"TRAEBELER_PROCESSOR_FROXLOR_RETRY_BACKOFF": "0"}, "TRAEBELER_PROCESSOR_FROXLOR_RETRY_BACKOFF has to be greater than 0 but is 0"},
+		{"maximum backoff below backoff", map[string]string{"TRAEBELER_PROCESSOR_FROXLOR_RETRY_BACKOFF_MAX": "10"}, "TRAEBELER_PROCESSOR_FROXLOR_RETRY_BACKOFF_MAX has to be at least the retry backoff of 30 but is 10"},
+		{"negative resync interval", map[string]string{"TRAEBELER_PROCESSOR_FROXLOR_RESYNC_INTERVAL": "-1"}, "TRAEBELER_PROCESSOR_FROXLOR_RESYNC_INTERVAL must not be negative but is -1"},
+		{"resync jitter above 100", map[string]string{"TRAEBELER_PROCESSOR_FROXLOR_RESYNC_JITTER": "101"}, "TRAEBELER_PROCESSOR_FROXLOR_RESYNC_JITTER has to be between 0 and 100 but is 101"},
+	}
+	for _, tt := range initTests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer test.ClearEnvs(test.SetEnvs(tt.vars))
+			p := Processor{}
+			assert.EqualError(t, p.Init(), tt.expected)
+		})
+	}
+}
+
+func TestProcess_whenRecordFails_shouldDelayNextAttempt(t *testing.T) {
+	mfh := mockFroxlorHandler{mockRecordHandler: mockRecordHandler{
+		addMock: func(domain, record, content, ttl, rtype string) error {
+			return errors.New("froxlor error")
+		},
+	}}
+	p := Processor{
+		cfg:      config{IPv4: true},
+		api:      &mfh,
+		ip:       mockIpProvider{},
+		owned:    newRegistry(),
+		failures: newFailureTracker(time.Minute, time.Hour, 0),
+	}
+	assert.NotNil(t, p.Process(context.Background(), toDomains("foo.bar")))
+	assert.Equal(t, 1, mfh.addInteractions)
+	assert.Len(t, p.Failures(), 1)
+
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")), "waiting records should not fail the run")
+	assert.Equal(t, 1, mfh.addInteractions, "failed record should not be retried before its next attempt")
+
+	p.failures.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	mfh.mockRecordHandler.addMock = nil
+	unchanged := domain.ChangeSet{Domains: toDomains("foo.bar"), Unchanged: toDomains("foo.bar"), IPv4: "127.0.0.1"}
+	assert.Nil(t, p.ProcessChanges(context.Background(), unchanged))
+	assert.Equal(t, 2, mfh.addInteractions, "failed record should be retried after the backoff even without changes")
+	assert.Empty(t, p.Failures())
+}
+
 func TestID_shouldReturnFroxlorProcessorID(t *testing.T) {
 	assert.Equal(t, "froxlor", (&Processor{}).ID(), "processor ID does not match")
 }
@@ -172,22 +264,24 @@ func TestID_shouldReturnFroxlorProcessorID(t *testing.T) {
 func TestProcess_whenIPLookupFails_shouldNotTriggerAnyInteraction(t *testing.T) {
 	mfh := mockFroxlorHandler{}
 	p := Processor{
+		cfg: config{IPv4: true},
 		ip: mockIpProvider{mockv4: func() (string, error) {
 			return "", errors.New("ip lookup error")
 		}},
 		api: &mfh,
 	}
-	p.Process([]string{"foo.bar", "sub.foo.bar"})
+	p.Process(context.Background(), toDomains("foo.bar", "sub.foo.bar"))
 	assert.Equal(t, 0, mfh.findInteractions, "expected no findDomainZones interactions")
 }
 
 func TestProcess_whenDomainsAreInvalid_shouldNotTriggerAnyInteraction(t *testing.T) {
 	mfh := mockFroxlorHandler{}
 	p := Processor{
+		cfg: config{IPv4: true},
 		ip:  mockIpProvider{},
 		api: &mfh,
 	}
-	p.Process([]string{"foo.bar", "sub--bar"})
+	p.Process(context.Background(), toDomains("foo.bar", "sub--bar"))
 	assert.Equal(t, 0, mfh.findInteractions, "expected no findDomainZones interactions")
 }
 
@@ -240,12 +334,348 @@ func TestEnsureDomainExistence_shouldAddMissingOnes(t *testing.T) {
 				existsMock:   tt.existMock,
 				addMock:      tt.addMock,
 			}
-			assert.Equal(t, tt.errExpected, ensureDomainExistence(&mdr, tt.rec) != nil, "error expectation mismatch")
+			assert.Equal(t, tt.errExpected, ensureDomainExistence(context.Background(), &mdr, tt.rec) != nil, "error expectation mismatch")
 			assert.Equal(t, tt.expectedInteractions, mdr.interactions, "interaction amount with froxlor api not matching")
 		})
 	}
 }
 
+func TestCollectGarbage_shouldOnlyDeleteOwnedRecordsOfRemovedDomains(t *testing.T) {
+	var gcTests = []struct {
+		name                 string
+		owned                []record
+		subdomainCreated     bool
+		zones                []zone
+		subdomains           bool
+		expectedZoneDeletes  int
+		expectedDomainDelete []string
+		expectedRemaining    int
+	}{
+		{
+			"removed domain with matching zone is deleted",
+			[]record{{"foo.bar", "old", "127.0.0.1", "A"}},
+			false,
+			[]zone{{"98", "1337", "18000", "old", "A", "127.0.0.1"}},
+			false,
+			1,
+			nil,
+			0,
+		},
+		{
+			"zone changed outside of traebeler is left untouched",
+			[]record{{"foo.bar", "old", "127.0.0.1", "A"}},
+			false,
+			[]zone{{"98", "1337", "18000", "old", "A", "192.168.178.1"}},
+			false,
+			0,
+			nil,
+			1,
+		},
+		{
+			"created subdomain is deleted if requested",
+			[]record{{"foo.bar", "old", "127.0.0.1", "A"}},
+			true,
+			[]zone{{"98", "1337", "18000", "old", "A", "127.0.0.1"}},
+			true,
+			1,
+			[]string{"old.foo.bar"},
+			0,
+		},
+		{
+			"subdomain not created by traebeler is kept",
+			[]record{{"foo.bar", "old", "127.0.0.1", "A"}},
+			false,
+			[]zone{{"98", "1337", "18000", "old", "A", "127.0.0.1"}},
+			true,
+			1,
+			nil,
+			0,
+		},
+		{
+			"still reported domains are kept",
+			[]record{{"foo.bar", "sub", "127.0.0.1", "A"}},
+			true,
+			[]zone{{"98", "1337", "18000", "sub", "A", "127.0.0.1"}},
+			true,
+			0,
+			nil,
+			1,
+		},
+	}
+	for _, tt := range gcTests {
+		t.Run(tt.name, func(t *testing.T) {
+			owned := newRegistry()
+			for _, o := range tt.owned {
+				owned.zoneAdded(o)
+				if tt.subdomainCreated {
+					owned.subdomainAdded(o)
+				}
+			}
+			mfh := mockFroxlorHandler{
+				mockRecordHandler: mockRecordHandler{
+					findMock: func(domain, record string) ([]zone, error) { return tt.zones, nil },
+				},
+			}
+			errs := collectGarbage(context.Background(), &mfh, owned, nil, "A", []string{"sub.foo.bar"}, tt.subdomains)
+			assert.Empty(t, errs, "garbage collection should not fail")
+			assert.Equal(t, tt.expectedZoneDeletes, mfh.deleteInteractions, "unexpected amount of zone deletions")
+			assert.Equal(t, tt.expectedDomainDelete, mfh.deletedDomains, "unexpected subdomain deletions")
+			assert.Len(t, owned.records, tt.expectedRemaining, "unexpected amount of remaining owned records")
+		})
+	}
+}
+
+func TestCollectGarbage_whenDeletionFails_shouldKeepOwnership(t *testing.T) {
+	owned := newRegistry()
+	owned.zoneAdded(record{"foo.bar", "old", "127.0.0.1", "A"})
+	mfh := mockFroxlorHandler{
+		mockRecordHandler: mockRecordHandler{
+			findMock: func(domain, record string) ([]zone, error) { return nil, errors.New("repo error") },
+		},
+	}
+	errs := collectGarbage(context.Background(), &mfh, owned, nil, "A", []string{}, false)
+	assert.Len(t, errs, 1, "the failed deletion should be returned")
+	assert.Len(t, owned.records, 1, "the record should be kept for the next garbage collection")
+}
+
+func TestEnsureAndUpdateRecord_whenSubdomainCreationFails_shouldCountFailure(t *testing.T) {
+	mfh := mockFroxlorHandler{mockDomainHandler: mockDomainHandler{
+		existsMock: func(fqn string) (bool, error) { return false, nil },
+		addMock:    func() error { return errors.New("repo error") },
+	}}
+	failed := recordsTotal(t, metrics.RecordFailed)
+	_, err := ensureAndUpdateRecord(context.Background(), &mfh, nil, record{"foo.bar", "sub", "127.0.0.1", "A"})
+	assert.NotNil(t, err)
+	assert.Equal(t, failed+1, recordsTotal(t, metrics.RecordFailed), "the failed subdomain creation should be counted")
+	assert.Equal(t, 0, mfh.addInteractions, "the record should not be added")
+}
+
+func TestCollectGarbage_shouldOnlyCountPerformedDeletions(t *testing.T) {
+	owned := newRegistry()
+	owned.zoneAdded(record{"foo.bar", "changed", "127.0.0.1", "A"})
+	owned.zoneAdded(record{"foo.bar", "old", "127.0.0.1", "A"})
+	owned.subdomainAdded(record{tld: "foo.bar", subdomain: "old"})
+	owned.subdomainAdded(record{tld: "foo.bar", subdomain: "broken"})
+	mfh := mockFroxlorHandler{
+		mockRecordHandler: mockRecordHandler{
+			findMock: func(domain, record string) ([]zone, error) {
+				if record == "changed" {
+					return []zone{{"97", "1337", "18000", record, "A", "192.168.178.1"}}, nil
+				}
+				return []zone{{"98", "1337", "18000", record, "A", "127.0.0.1"}}, nil
+			},
+		},
+		mockDomainHandler: mockDomainHandler{
+			deleteMock: func(fqn string) error {
+				if fqn == "broken.foo.bar" {
+					return errors.New("repo error")
+				}
+				return nil
+			},
+		},
+	}
+	deleted, failed := recordsTotal(t, metrics.RecordDeleted), recordsTotal(t, metrics.RecordFailed)
+
+	errs := collectGarbage(context.Background(), &mfh, owned, nil, "A", []string{}, true)
+	assert.Len(t, errs, 1, "the failed subdomain deletion should be returned")
+	assert.Equal(t, deleted+2, recordsTotal(t, metrics.RecordDeleted), "the zone record and subdomain of 'old' should be counted as deleted")
+	assert.Equal(t, failed+1, recordsTotal(t, metrics.RecordFailed), "the failed subdomain deletion should be counted")
+	assert.Equal(t, map[string]record{"changed.foo.bar/A": {"foo.bar", "changed", "127.0.0.1", "A"}}, owned.records, "the untouched record should still be owned")
+}
+
+func TestProcess_whenGarbageCollectionEnabled_shouldDeleteRecordsOfRemovedDomains(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	p := Processor{cfg: config{GarbageCollect: true, IPv4: true}, ip: mockIpProvider{}, owned: newRegistry()}
+	p.api = trackingHandler{froxlorHandler: &mfh, owned: p.owned}
+
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar", "sub.foo.bar")))
+	assert.Len(t, p.owned.records, 2, "added records should be owned")
+
+	mfh.findMock = func(domain, record string) ([]zone, error) {
+		return []zone{{"98", "1337", "18000", record, "A", "127.0.0.1"}}, nil
+	}
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")))
+	assert.Equal(t, 1, mfh.deleteInteractions, "zone record of removed domain should be deleted")
+	assert.Len(t, p.owned.records, 1, "deleted record should not be owned anymore")
+}
+
+func TestProcess_whenIPv6Enabled_shouldManageAAAARecords(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	var added []string
+	mfh.mockRecordHandler.addMock = func(domain, record, content, ttl, rtype string) error {
+		added = append(added, rtype+" "+content)
+		return nil
+	}
+	p := Processor{cfg: config{IPv4: true, IPv6: true}, ip: mockIpProvider{}, owned: newRegistry()}
+	p.api = trackingHandler{froxlorHandler: &mfh, owned: p.owned}
+
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")))
+	assert.Equal(t, []string{"A 127.0.0.1", "AAAA ::1"}, added, "both address records should be added")
+	assert.ElementsMatch(t, []record{{"foo.bar", "@", "127.0.0.1", "A"}, {"foo.bar", "@", "::1", "AAAA"}}, p.cache)
+}
+
+func TestProcess_whenIPv6LookupFails_shouldRemoveOwnedAAAARecords(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	p := Processor{
+		cfg: config{IPv4: true, IPv6: true},
+		ip: mockIpProvider{mockv6: func() (string, error) {
+			return "", errors.New("no ipv6 connectivity")
+		}},
+		owned: newRegistry(),
+		cache: []record{{"foo.bar", "@", "127.0.0.1", "A"}, {"foo.bar", "@", "::1", "AAAA"}},
+	}
+	p.owned.zoneAdded(record{"foo.bar", "@", "127.0.0.1", "A"})
+	p.owned.zoneAdded(record{"foo.bar", "@", "::1", "AAAA"})
+	p.api = trackingHandler{froxlorHandler: &mfh, owned: p.owned}
+	mfh.findMock = func(domain, record string) ([]zone, error) {
+		return []zone{{"97", "1337", "18000", "@", "A", "127.0.0.1"}, {"98", "1337", "18000", "@", "AAAA", "::1"}}, nil
+	}
+	var deletes []string
+	mfh.mockRecordHandler.deleteMock = func(domain, entryID string) error {
+		deletes = append(deletes, entryID)
+		return nil
+	}
+
+	assert.NotNil(t, p.Process(context.Background(), toDomains("foo.bar")), "the failed address detection should be returned")
+	assert.Equal(t, []string{"98"}, deletes, "only the AAAA record should be deleted")
+	assert.Equal(t, []record{{"foo.bar", "@", "127.0.0.1", "A"}}, p.cache, "AAAA records should be dropped from the cache")
+	assert.Equal(t, map[string]record{"foo.bar/A": {"foo.bar", "@", "127.0.0.1", "A"}}, p.owned.records)
+}
+
+func TestProcess_whenIPv6LookupFailsPermanently_shouldOnlyFailFirstRun(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	detections := 0
+	var detected error = errors.New("no ipv6 connectivity")
+	p := Processor{
+		cfg: config{IPv4: true, IPv6: true, RetryBackoff: 60, RetryBackoffMax: 3600},
+		api: &mfh,
+		ip: mockIpProvider{mockv6: func() (string, error) {
+			detections++
+			if detected != nil {
+				return "", detected
+			}
+			return "::1", nil
+		}},
+		owned: newRegistry(),
+	}
+
+	assert.NotNil(t, p.Process(context.Background(), toDomains("foo.bar")), "the first failed address detection should be returned")
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")), "the lost address should not fail further runs")
+	assert.Equal(t, 1, detections, "the lost address should not be detected again before its backoff passed")
+
+	unchanged := domain.ChangeSet{Unchanged: toDomains("foo.bar"), Domains: toDomains("foo.bar"), IPv4: "127.0.0.1"}
+	finds := mfh.findInteractions
+	assert.Nil(t, p.ProcessChanges(context.Background(), unchanged))
+	assert.Equal(t, finds, mfh.findInteractions, "unchanged domains should be skipped while the address is lost")
+
+	p.ipv6Loss.next = time.Now().Add(-time.Second)
+	assert.Nil(t, p.ProcessChanges(context.Background(), unchanged), "further failed address detections should not be returned")
+	assert.Equal(t, 2, detections, "the lost address should be detected again once its backoff passed")
+	assert.Equal(t, 2, p.ipv6Loss.failures)
+	assert.True(t, p.ipv6Loss.next.After(time.Now().Add(time.Minute)), "the backoff should be doubled")
+
+	p.ipv6Loss.next = time.Now().Add(-time.Second)
+	detected = nil
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")))
+	assert.Nil(t, p.ipv6Loss, "the address should be available again")
+	assert.Contains(t, p.cache, record{"foo.bar", "@", "::1", "AAAA"})
+}
+
+func TestProcess_whenIPv6LookupFails_shouldKeepSubdomainsOfReportedDomains(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	p := Processor{
+		cfg: config{IPv6: true, GarbageCollect: true, GarbageCollectSubdomains: true},
+		ip: mockIpProvider{mockv6: func() (string, error) {
+			return "", errors.New("no ipv6 connectivity")
+		}},
+		owned: newRegistry(),
+		cache: []record{{"foo.bar", "sub", "::1", "AAAA"}},
+	}
+	p.owned.zoneAdded(record{"foo.bar", "sub", "::1", "AAAA"})
+	p.owned.subdomainAdded(record{tld: "foo.bar", subdomain: "sub"})
+	p.owned.subdomainAdded(record{tld: "foo.bar", subdomain: "old"})
+	p.api = trackingHandler{froxlorHandler: &mfh, owned: p.owned}
+	mfh.findMock = func(domain, record string) ([]zone, error) {
+		return []zone{{"98", "1337", "18000", record, "AAAA", "::1"}}, nil
+	}
+
+	assert.NotNil(t, p.Process(context.Background(), toDomains("sub.foo.bar")), "the failed address detection should be returned")
+	assert.Equal(t, 1, mfh.deleteInteractions, "the AAAA record should be deleted")
+	assert.Equal(t, []string{"old.foo.bar"}, mfh.deletedDomains, "only the subdomain of the removed domain should be deleted")
+}
+
+func TestProcess_withTargets_shouldWriteDistinctAddressesInOneCycle(t *testing.T) {
+	var targetTests = []struct {
+		name     string
+		envs     map[string]string
+		detect   func() (string, error)
+		expected []string
+	}{
+		{
+			"overridden domains point to their target",
+			map[string]string{"TRAEBELER_TARGET_OVERRIDES": "*.lan.foo.bar=10.0.0.5"},
+			nil,
+			[]string{"@ 127.0.0.1", "nas.lan 10.0.0.5"},
+		},
+		{
+			"static target does not require detection",
+			map[string]string{"TRAEBELER_TARGET_IPV4": "203.0.113.1", "TRAEBELER_TARGET_OVERRIDES": "nas.lan.foo.bar=10.0.0.5"},
+			func() (string, error) { return "", errors.New("ip lookup error") },
+			[]string{"@ 203.0.113.1", "nas.lan 10.0.0.5"},
+		},
+	}
+	for _, tt := range targetTests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer test.ClearEnvs(test.SetEnvs(tt.envs))
+			resolver, err := target.NewResolver()
+			assert.Nil(t, err)
+			mfh := mockFroxlorHandler{}
+			var added []string
+			mfh.mockRecordHandler.addMock = func(domain, record, content, ttl, rtype string) error {
+				added = append(added, record+" "+content)
+				return nil
+			}
+			p := Processor{cfg: config{IPv4: true}, api: &mfh, ip: mockIpProvider{mockv4: tt.detect}, targets: resolver}
+
+			assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar", "nas.lan.foo.bar")))
+			assert.ElementsMatch(t, tt.expected, added)
+			assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar", "nas.lan.foo.bar")))
+			assert.Len(t, added, 2, "cached targets should not be updated again")
+		})
+	}
+}
+
+func TestProcessChanges_shouldUseAddressesOfChangesAndSkipWithoutChanges(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	var added []string
+	mfh.mockRecordHandler.addMock = func(domain, record, content, ttl, rtype string) error {
+		added = append(added, rtype+" "+content)
+		return nil
+	}
+	detections := 0
+	p := Processor{cfg: config{IPv4: true, IPv6: true}, api: &mfh, ip: mockIpProvider{mockv6: func() (string, error) {
+		detections++
+		return "2606:2800:220:1:248:1893:25c8:1946", nil
+	}}}
+
+	changes := domain.ChangeSet{Added: toDomains("foo.bar"), Domains: toDomains("foo.bar"), IPv4: "93.184.216.34", IPChanged: true}
+	assert.Nil(t, p.ProcessChanges(context.Background(), changes))
+	assert.Equal(t, []string{"A 93.184.216.34", "AAAA 2606:2800:220:1:248:1893:25c8:1946"}, added)
+	assert.Equal(t, 1, detections, "only unwatched addresses should be detected")
+
+	changes = domain.ChangeSet{Unchanged: toDomains("foo.bar"), Domains: toDomains("foo.bar"), IPv4: "93.184.216.34"}
+	assert.Nil(t, p.ProcessChanges(context.Background(), changes))
+	assert.Equal(t, 2, detections, "domains should be processed as long as an address is not watched")
+
+	changes.IPv6 = "2606:2800:220:1:248:1893:25c8:1946"
+	finds := mfh.findInteractions
+	assert.Nil(t, p.ProcessChanges(context.Background(), changes))
+	assert.Equal(t, finds, mfh.findInteractions, "nothing should be processed without any changes")
+}
+
+// mockMu guards the interactions of the mock handlers which are called concurrently while updating records
+var mockMu sync.Mutex
+
 type mockRecordHandler struct {
 	findMock func(domain, record string) ([]zone, error)
 	findInteractions int
@@ -255,52 +685,83 @@ type mockRecordHandler struct {
 	deleteInteractions int
 }
 
-func (mrh *mockRecordHandler) findDomainZones(domain, record string) ([]zone, error) {
+func (mrh *mockRecordHandler) findDomainZones(_ context.Context, domain, record string) ([]zone, error) {
+	mockMu.Lock()
 	mrh.findInteractions++
+	mockMu.Unlock()
 	if mrh.findMock != nil {
 		return mrh.findMock(domain,record)
 	}
 	return []zone{}, nil
 }
 
-func (mrh *mockRecordHandler) addDomainZone(domain, record, content, ttl, rtype string) error {
+func (mrh *mockRecordHandler) addDomainZone(_ context.Context, domain, record, content, ttl, rtype string) error {
+	mockMu.Lock()
 	mrh.addInteractions++
+	mockMu.Unlock()
 	if mrh.addMock != nil {
 		return mrh.addMock(domain, record, content, ttl, rtype)
 	}
 	return nil
 }
 
-func (mrh *mockRecordHandler) deleteDomainZone(domain, entryID string) error {
+func (mrh *mockRecordHandler) deleteDomainZone(_ context.Context, domain, entryID string) error {
+	mockMu.Lock()
 	mrh.deleteInteractions++
+	mockMu.Unlock()
 	if mrh.deleteMock != nil {
 		return mrh.deleteMock(domain, entryID)
 	}
 	return nil
 }
 
+func (mrh *mockRecordHandler) claim(_ record) {}
+
+func (mrh *mockRecordHandler) planned() bool {
+	return false
+}
+
 type mockDomainHandler struct {
 	interactions int
 	existsMock func(fqn string)(bool, error)
 	addMock func() error
+	deleteMock func(fqn string) error
+	deletedDomains []string
 }
 
-func (mdh *mockDomainHandler) domainExists(fqn string) (bool, error) {
+func (mdh *mockDomainHandler) domainExists(_ context.Context, fqn string) (bool, error) {
+	mockMu.Lock()
 	mdh.interactions++
+	mockMu.Unlock()
 	if mdh.existsMock != nil {
 		return mdh.existsMock(fqn)
 	}
 	return true, nil
 }
 
-func (mdh *mockDomainHandler) addDomain(_, _ string) error {
+func (mdh *mockDomainHandler) addDomain(_ context.Context, _, _ string) error {
+	mockMu.Lock()
 	mdh.interactions++
+	mockMu.Unlock()
 	if mdh.addMock != nil {
 		return mdh.addMock()
 	}
 	return nil
 }
 
+func (mdh *mockDomainHandler) deleteDomain(_ context.Context, fqn string) error {
+	mockMu.Lock()
+	mdh.interactions++
+	mockMu.Unlock()
+	if mdh.deleteMock != nil {
+		if err := mdh.deleteMock(fqn); err != nil {
+			return err
+		}
+	}
+	mdh.deletedDomains = append(mdh.deletedDomains, fqn)
+	return nil
+}
+
 type mockFroxlorHandler struct {
 	mockRecordHandler
 	mockDomainHandler
@@ -308,11 +769,27 @@ type mockFroxlorHandler struct {
 
 type mockIpProvider struct {
 	mockv4 func() (string,error)
+	mockv6 func() (string,error)
 }
 
-func (mip mockIpProvider) ipv4() (string, error) {
+func (mip mockIpProvider) ipv4(_ context.Context) (string, error) {
 	if mip.mockv4 != nil {
 		return mip.mockv4()
 	}
 	return "127.0.0.1", nil
-}
\ No newline at end of file
+}
+func (mip mockIpProvider) ipv6(_ context.Context) (string, error) {
+	if mip.mockv6 != nil {
+		return mip.mockv6()
+	}
+	return "::1", nil
+}
+
+// toDomains converts domain names to domains which are not served on any specific entrypoint.
+func toDomains(names ...string) []domain.Domain {
+	var domains []domain.Domain
+	for _, name := range names {
+		domains = append(domains, domain.Domain{Name: name})
+	}
+	return domains
+}
@@ -0,0 +1,175 @@
+package froxlor
+
+import (
+	"context"
+	"github.com/jenpet/traebeler/internal/log"
+	"github.com/jenpet/traebeler/internal/metrics"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// resyncer expires cached records once the resync interval passed since they were last synced, hence they are
+// verified against Froxlor once more. Every record expires up to jitter percent of the interval earlier at random to
+// spread the lookups over multiple cycles. Records with an unknown sync time are expired. A nil resyncer never expires
+// any record.
+type resyncer struct {
+	interval time.Duration
+	// jitter is the percentage of the interval a record expires earlier at most
+	jitter int
+	now    func() time.Time
+	// random returns a random duration in [0, n)
+	random  func(n int64) int64
+	expires map[string]time.Time
+}
+
+// newResyncer returns a resyncer for the given interval, nil in case the interval disables resyncs.
+func newResyncer(interval time.Duration, jitter int) *resyncer {
+	if interval <= 0 {
+		return nil
+	}
+	return &resyncer{
+		interval: interval,
+		jitter:   jitter,
+		now:      time.Now,
+		random:   rand.Int63n,
+		expires:  map[string]time.Time{},
+	}
+}
+
+// synced schedules the next verification of a record which was synced at the given time.
+func (rs *resyncer) synced(rec record, at time.Time) {
+	if rs == nil {
+		return
+	}
+	expiry := rs.interval
+	if spread := int64(rs.interval) * int64(rs.jitter) / 100; spread > 0 {
+		expiry -= time.Duration(rs.random(spread))
+	}
+	rs.expires[registryKey(rec)] = at.Add(expiry)
+}
+
+// prune forgets the expiry of every record whose registry key is not kept.
+func (rs *resyncer) prune(kept map[string]bool) {
+	if rs == nil {
+		return
+	}
+	for key := range rs.expires {
+		if !kept[key] {
+			delete(rs.expires, key)
+		}
+	}
+}
+
+// expired returns true in case the record has to be verified.
+func (rs *resyncer) expired(rec record) bool {
+	if rs == nil {
+		return false
+	}
+	expiry, ok := rs.expires[registryKey(rec)]
+	return !ok || !rs.now().Before(expiry)
+}
+
+// due returns true in case any of the records has to be verified.
+func (rs *resyncer) due(recs []record) bool {
+	for _, rec := range recs {
+		if rs.expired(rec) {
+			return true
+		}
+	}
+	return false
+}
+
+// markSynced records that the record was written or verified in Froxlor at the given time.
+func (p *Processor) markSynced(rec record, at time.Time) {
+	if p.synced == nil {
+		p.synced = map[string]time.Time{}
+	}
+	p.synced[registryKey(rec)] = at
+	p.resync.synced(rec, at)
+}
+
+// pruneSynced forgets the sync times of records which are not cached anymore, e.g. since their domain was removed or
+// they were garbage collected.
+func (p *Processor) pruneSynced() {
+	cached := map[string]bool{}
+	for _, entry := range p.cache {
+		cached[registryKey(entry)] = true
+	}
+	for key := range p.synced {
+		if !cached[key] {
+			delete(p.synced, key)
+		}
+	}
+	p.resync.prune(cached)
+}
+
+// resyncCache verifies the expired cache entries of the given record type which still point to their target against
+// the zone records in Froxlor. Entries which drifted, e.g. since their zone record was changed or deleted in the
+// Froxlor panel, are dropped from the cache and therefore repaired by the following update. Entries which are not owned
+// by the instance anymore are dropped as well, the following update then decides whether they are adopted or left
+// untouched. Entries which can't be verified are kept and verified in the next cycle.
+func (p *Processor) resyncCache(ctx context.Context, rtype string, targets map[string]string) {
+	if p.resync == nil {
+		return
+	}
+	kept := []record{}
+	verified, drifted := 0, 0
+	for _, entry := range p.cache {
+		if entry.rtype != rtype || targets[entry.fqn()] != entry.ip || !p.resync.expired(entry) || ctx.Err() != nil {
+			kept = append(kept, entry)
+			continue
+		}
+		if p.registry != nil {
+			state, _, err := p.registry.ownership(ctx, p.api, entry)
+			if err != nil {
+				log.Errorf("Failed verifying ownership of %s record of domain '%s'. Error: %v", rtype, entry.fqn(), err)
+				kept = append(kept, entry)
+				continue
+			}
+			if state != recordOwned {
+				log.Infof("%s record of domain '%s' is not owned by this instance anymore. Dropping it from the cache.", rtype, entry.fqn())
+				continue
+			}
+		}
+		inSync, err := verifyRecord(ctx, p.api, entry)
+		if err != nil {
+			log.Errorf("Failed verifying %s record of domain '%s'. Error: %v", rtype, entry.fqn(), err)
+			kept = append(kept, entry)
+			continue
+		}
+		verified++
+		// a dry run verifies the records again in every plan
+		if !inSync {
+			drifted++
+			recordOperation(p.api, metrics.RecordDrifted)
+			continue
+		}
+		if p.dryRun == nil {
+			p.markSynced(entry, time.Now())
+		}
+		kept = append(kept, entry)
+	}
+	p.cache = kept
+	if verified > 0 {
+		log.Infof("Verified %d %s records against Froxlor, %d of them drifted.", verified, rtype, drifted)
+	}
+}
+
+// verifyRecord returns true in case the record's ip is the content of the only zone record of its name and type.
+func verifyRecord(ctx context.Context, rh recordHandler, rec record) (bool, error) {
+	zones, err := rh.findDomainZones(ctx, rec.tld, rec.subdomain)
+	if err != nil {
+		return false, err
+	}
+	zones = filterZones(zones, rec.rtype)
+	if len(zones) == 1 && zones[0].Content == rec.ip {
+		return true, nil
+	}
+	var contents []string
+	for _, z := range zones {
+		contents = append(contents, z.Content)
+	}
+	log.Infof("DRIFT: %s record of domain '%s' should point to '%s' but Froxlor holds [%s]. Repairing it.", rec.rtype, rec.fqn(), rec.ip, strings.Join(contents, ", "))
+	return false, nil
+}
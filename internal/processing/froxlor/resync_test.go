@@ -0,0 +1,164 @@
+package froxlor
+
+import (
+	"context"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestResyncer_shouldExpireRecordsAfterIntervalWithJitter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	rs := newResyncer(time.Hour, 10)
+	rs.now = func() time.Time { return now }
+	rs.random = func(n int64) int64 {
+		assert.Equal(t, int64(6*time.Minute), n, "records should expire up to 10% of the interval earlier")
+		return int64(4 * time.Minute)
+	}
+	rec := record{"foo.bar", "sub", "93.184.216.34", "A"}
+
+	assert.True(t, rs.expired(rec), "records with an unknown sync time should be expired")
+	rs.synced(rec, now)
+	assert.False(t, rs.expired(rec))
+	assert.False(t, rs.due([]record{rec}))
+	now = now.Add(55 * time.Minute)
+	assert.False(t, rs.expired(rec))
+	now = now.Add(time.Minute)
+	assert.True(t, rs.expired(rec), "record should expire after the interval minus its jitter")
+	assert.True(t, rs.due([]record{rec}))
+
+	assert.Nil(t, newResyncer(0, 10), "resyncs should be disabled without interval")
+	assert.False(t, (*resyncer)(nil).expired(rec), "records should never expire without resyncs")
+}
+
+func TestProcess_whenResyncIntervalPassed_shouldRepairDriftedRecords(t *testing.T) {
+	var driftTests = []struct {
+		name    string
+		zones   []zone
+		finds   int
+		deletes int
+		adds    int
+	}{
+		{"record in sync", []zone{{"98", "1337", "18000", "sub", "A", "127.0.0.1"}}, 1, 0, 0},
+		{"record changed in panel", []zone{{"98", "1337", "18000", "sub", "A", "93.184.216.34"}}, 2, 1, 1},
+		{"record deleted in panel", []zone{}, 2, 0, 1},
+	}
+	for _, tt := range driftTests {
+		t.Run(tt.name, func(t *testing.T) {
+			mfh := mockFroxlorHandler{}
+			mfh.findMock = func(domain, record string) ([]zone, error) { return tt.zones, nil }
+			cached := record{"foo.bar", "sub", "127.0.0.1", "A"}
+			p := Processor{
+				cfg:    config{IPv4: true},
+				api:    &mfh,
+				ip:     mockIpProvider{},
+				owned:  newRegistry(),
+				cache:  []record{cached},
+				resync: newResyncer(time.Hour, 0),
+			}
+			p.markSynced(cached, time.Now().Add(-2*time.Hour))
+
+			assert.Nil(t, p.Process(context.Background(), toDomains("sub.foo.bar")))
+			assert.Equal(t, tt.finds, mfh.findInteractions)
+			assert.Equal(t, tt.deletes, mfh.deleteInteractions)
+			assert.Equal(t, tt.adds, mfh.addInteractions)
+			assert.Equal(t, []record{cached}, p.cache)
+			assert.False(t, p.resync.expired(cached), "verified or repaired record should not be expired anymore")
+		})
+	}
+}
+
+func TestProcess_withRegistry_shouldOnlyRepairOwnedRecords(t *testing.T) {
+	ownedMarker := zone{"99", "1337", "18000", "_traebeler.sub", "TXT", "heritage=traebeler,traebeler/instance=test"}
+	foreignMarker := zone{"99", "1337", "18000", "_traebeler.sub", "TXT", "heritage=traebeler,traebeler/instance=other"}
+	var ownershipTests = []struct {
+		name    string
+		markers []zone
+		deletes int
+		adds    int
+		cached  bool
+	}{
+		{"owned record is repaired", []zone{ownedMarker}, 1, 1, true},
+		{"unowned record is left untouched", nil, 0, 0, false},
+		{"foreign record is left untouched", []zone{foreignMarker}, 0, 0, false},
+	}
+	for _, tt := range ownershipTests {
+		t.Run(tt.name, func(t *testing.T) {
+			mfh := mockFroxlorHandler{}
+			mfh.findMock = func(domain, record string) ([]zone, error) {
+				if record == "_traebeler.sub" {
+					return tt.markers, nil
+				}
+				return []zone{{"98", "1337", "18000", "sub", "A", "93.184.216.34"}}, nil
+			}
+			cached := record{"foo.bar", "sub", "127.0.0.1", "A"}
+			p := Processor{
+				cfg:      config{IPv4: true},
+				api:      &mfh,
+				ip:       mockIpProvider{},
+				owned:    newRegistry(),
+				registry: &txtRegistry{instanceID: "test"},
+				cache:    []record{cached},
+				resync:   newResyncer(time.Hour, 0),
+			}
+			p.markSynced(cached, time.Now().Add(-2*time.Hour))
+
+			assert.Nil(t, p.Process(context.Background(), toDomains("sub.foo.bar")))
+			assert.Equal(t, tt.deletes, mfh.deleteInteractions)
+			assert.Equal(t, tt.adds, mfh.addInteractions)
+			if tt.cached {
+				assert.Equal(t, []record{cached}, p.cache)
+			} else {
+				assert.Empty(t, p.cache, "record which is not owned should not be kept in the cache")
+			}
+		})
+	}
+}
+
+func TestProcess_whenDomainIsRemoved_shouldForgetItsSyncTime(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	p := Processor{
+		cfg:    config{IPv4: true, GarbageCollect: true},
+		ip:     mockIpProvider{},
+		owned:  newRegistry(),
+		resync: newResyncer(time.Hour, 0),
+	}
+	p.api = trackingHandler{froxlorHandler: &mfh, owned: p.owned}
+
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar", "sub.foo.bar", "old.foo.bar")))
+	assert.Len(t, p.synced, 3)
+	assert.Len(t, p.resync.expires, 3)
+
+	mfh.findMock = func(domain, record string) ([]zone, error) {
+		return []zone{{"98", "1337", "18000", record, "A", "127.0.0.1"}}, nil
+	}
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar", "sub.foo.bar")))
+	assert.Equal(t, 1, mfh.deleteInteractions, "the record of the removed domain should be garbage collected")
+	assert.Len(t, p.synced, 2, "the sync time of the removed domain should be forgotten")
+	assert.NotContains(t, p.synced, "old.foo.bar/A")
+	assert.Len(t, p.resync.expires, 2, "the expiry of the removed domain should be forgotten")
+	assert.NotContains(t, p.resync.expires, "old.foo.bar/A")
+}
+
+func TestProcessChanges_whenRecordExpired_shouldNotSkip(t *testing.T) {
+	mfh := mockFroxlorHandler{}
+	mfh.findMock = func(domain, record string) ([]zone, error) {
+		return []zone{{"98", "1337", "18000", "@", "A", "127.0.0.1"}}, nil
+	}
+	cached := record{"foo.bar", "@", "127.0.0.1", "A"}
+	p := Processor{
+		cfg:    config{IPv4: true},
+		api:    &mfh,
+		ip:     mockIpProvider{},
+		owned:  newRegistry(),
+		cache:  []record{cached},
+		resync: newResyncer(time.Hour, 0),
+	}
+	unchanged := domain.ChangeSet{Domains: toDomains("foo.bar"), Unchanged: toDomains("foo.bar"), IPv4: "127.0.0.1"}
+
+	assert.Nil(t, p.ProcessChanges(context.Background(), unchanged))
+	assert.Equal(t, 1, mfh.findInteractions, "expired record should be verified even without changes")
+	assert.Nil(t, p.ProcessChanges(context.Background(), unchanged))
+	assert.Equal(t, 1, mfh.findInteractions, "verified record should not be verified again before it expires")
+}
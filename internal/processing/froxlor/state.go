@@ -0,0 +1,114 @@
+package froxlor
+
+import (
+	"github.com/jenpet/traebeler/internal/log"
+	"time"
+)
+
+// stateVersion is the version of the persisted state's schema. It has to be increased on incompatible changes of
+// persistedState, states of older versions have to be migrated in restoreState.
+const stateVersion = 1
+
+// persistedState is the state of the processor which survives restarts. It allows to skip looking up every record
+// in Froxlor after a restart and to clean up records created before it.
+type persistedState struct {
+	Version int       `json:"version"`
+	SavedAt time.Time `json:"savedAt"`
+	// URI of the Froxlor API the state belongs to, the state is discarded once it changes
+	URI string `json:"uri"`
+	// Records are the cached records which are in sync with Froxlor
+	Records []persistedRecord `json:"records"`
+	// OwnedRecords and OwnedSubdomains were created by traebeler
+	OwnedRecords    []persistedRecord `json:"ownedRecords"`
+	OwnedSubdomains []persistedRecord `json:"ownedSubdomains"`
+}
+
+type persistedRecord struct {
+	Domain    string `json:"domain"`
+	Subdomain string `json:"subdomain"`
+	Type      string `json:"type,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	// SyncedAt is the time the record was last written or verified in Froxlor
+	SyncedAt *time.Time `json:"syncedAt,omitempty"`
+}
+
+func toPersisted(recs []record, synced map[string]time.Time) []persistedRecord {
+	persisted := []persistedRecord{}
+	for _, rec := range recs {
+		pr := persistedRecord{Domain: rec.tld, Subdomain: rec.subdomain, Type: rec.rtype, IP: rec.ip}
+		if at, ok := synced[registryKey(rec)]; ok {
+			pr.SyncedAt = &at
+		}
+		persisted = append(persisted, pr)
+	}
+	return persisted
+}
+
+func fromPersisted(persisted []persistedRecord) []record {
+	recs := []record{}
+	for _, pr := range persisted {
+		recs = append(recs, record{tld: pr.Domain, subdomain: pr.Subdomain, ip: pr.IP, rtype: pr.Type})
+	}
+	return recs
+}
+
+// loadState restores the cache and the owned records from the store. A state which can't be read is discarded, every
+// record is looked up in Froxlor once more then.
+func (p *Processor) loadState() {
+	if p.store == nil {
+		return
+	}
+	var s persistedState
+	ok, err := p.store.Load(&s)
+	if err != nil {
+		log.Errorf("Failed loading the state of the Froxlor processor, starting without it. Error: %v", err)
+		return
+	}
+	if !ok {
+		log.Info("No state of the Froxlor processor was saved yet.")
+		return
+	}
+	p.restoreState(s)
+}
+
+func (p *Processor) restoreState(s persistedState) {
+	switch {
+	case s.Version < 1 || s.Version > stateVersion:
+		log.Errorf("Discarding state of the Froxlor processor with unsupported version %d, expected version %d.", s.Version, stateVersion)
+		return
+	case s.URI != p.cfg.URI:
+		log.Infof("Discarding state of the Froxlor processor since it belongs to the API '%s'.", s.URI)
+		return
+	}
+	p.cache = fromPersisted(s.Records)
+	for _, pr := range s.Records {
+		if pr.SyncedAt != nil {
+			p.markSynced(record{tld: pr.Domain, subdomain: pr.Subdomain, rtype: pr.Type}, *pr.SyncedAt)
+		}
+	}
+	p.owned.restore(fromPersisted(s.OwnedRecords), fromPersisted(s.OwnedSubdomains))
+	// an empty domain list right after a restart is rather caused by a provider which isn't ready yet than by removed
+	// domains
+	p.restored = len(s.OwnedRecords) > 0 || len(s.OwnedSubdomains) > 0
+	log.Infof("Restored %d cached and %d owned records of the Froxlor processor saved at %s.", len(s.Records), len(s.OwnedRecords), s.SavedAt.Format(time.RFC3339))
+}
+
+// saveState writes the cache and the owned records to the store. Nothing is saved during a dry run since the planned
+// changes were never applied.
+func (p *Processor) saveState() {
+	if p.store == nil || p.dryRun != nil {
+		return
+	}
+	records, subdomains := p.owned.snapshot()
+	s := persistedState{
+		Version:         stateVersion,
+		SavedAt:         time.Now(),
+		URI:             p.cfg.URI,
+		Records:         toPersisted(p.cache, p.synced),
+		OwnedRecords:    toPersisted(records, nil),
+		OwnedSubdomains: toPersisted(subdomains, nil),
+	}
+	if err := p.store.Save(s); err != nil {
+		log.Errorf("Failed saving the state of the Froxlor processor. Error: %v", err)
+	}
+}
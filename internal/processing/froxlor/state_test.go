@@ -0,0 +1,115 @@
+package froxlor
+
+import (
+	"context"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/jenpet/traebeler/internal/state"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newStoredProcessor(store state.Store, mfh *mockFroxlorHandler) *Processor {
+	p := &Processor{
+		cfg:   config{URI: "https://froxlor.example.com/api.php", GarbageCollect: true, IPv4: true},
+		ip:    mockIpProvider{},
+		owned: newRegistry(),
+		store: store,
+	}
+	p.api = trackingHandler{froxlorHandler: mfh, owned: p.owned}
+	p.loadState()
+	return p
+}
+
+func TestProcess_withStore_shouldRestoreStateAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traebeler-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store := state.NewFileStore(filepath.Join(dir, "froxlor.json"))
+
+	first := mockFroxlorHandler{}
+	p := newStoredProcessor(store, &first)
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar", "sub.foo.bar")))
+	assert.Equal(t, 2, first.addInteractions)
+
+	restarted := mockFroxlorHandler{}
+	restarted.findMock = func(domain, record string) ([]zone, error) {
+		return []zone{{"98", "1337", "18000", record, "A", "127.0.0.1"}}, nil
+	}
+	p = newStoredProcessor(store, &restarted)
+	assert.ElementsMatch(t, []record{{"foo.bar", "@", "127.0.0.1", "A"}, {"foo.bar", "sub", "127.0.0.1", "A"}}, p.cache)
+	assert.Len(t, p.synced, 2, "sync times of the cached records should be restored")
+
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar", "sub.foo.bar")))
+	assert.Equal(t, 0, restarted.findInteractions, "restored records should not be looked up again")
+	assert.Equal(t, 0, restarted.mockDomainHandler.interactions, "domains of restored records should not be looked up again")
+
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")))
+	assert.Equal(t, 1, restarted.deleteInteractions, "record created before the restart should be deleted once its domain is removed")
+	assert.Len(t, p.owned.records, 1)
+}
+
+func TestProcessChanges_whenNoDomainsReportedAfterRestart_shouldKeepRestoredRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traebeler-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store := state.NewFileStore(filepath.Join(dir, "froxlor.json"))
+
+	p := newStoredProcessor(store, &mockFroxlorHandler{})
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar", "sub.foo.bar")))
+
+	restarted := mockFroxlorHandler{}
+	restarted.findMock = func(domain, record string) ([]zone, error) {
+		return []zone{{"98", "1337", "18000", record, "A", "127.0.0.1"}}, nil
+	}
+	p = newStoredProcessor(store, &restarted)
+	empty := domain.ChangeSet{Removed: toDomains("foo.bar", "sub.foo.bar"), IPv4: "127.0.0.1"}
+	assert.Nil(t, p.ProcessChanges(context.Background(), empty))
+	assert.Equal(t, 0, restarted.deleteInteractions, "restored records should not be deleted before domains are reported")
+	assert.Len(t, p.owned.records, 2)
+
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")))
+	assert.Equal(t, 1, restarted.deleteInteractions, "restored record should be deleted once its domain is missing in reported domains")
+
+	assert.Nil(t, p.Process(context.Background(), nil))
+	assert.Equal(t, 2, restarted.deleteInteractions, "records should be deleted once domains were reported after the restart")
+	assert.Empty(t, p.owned.records)
+}
+
+func TestRestoreState_whenStateDoesNotMatch_shouldDiscardIt(t *testing.T) {
+	var restoreTests = []struct {
+		name  string
+		state persistedState
+	}{
+		{"newer version", persistedState{Version: stateVersion + 1, URI: "https://froxlor.example.com/api.php"}},
+		{"missing version", persistedState{URI: "https://froxlor.example.com/api.php"}},
+		{"different API", persistedState{Version: stateVersion, URI: "https://other.example.com/api.php"}},
+	}
+	for _, tt := range restoreTests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.state.Records = []persistedRecord{{Domain: "foo.bar", Subdomain: "@", Type: "A", IP: "127.0.0.1"}}
+			tt.state.OwnedRecords = tt.state.Records
+			p := Processor{cfg: config{URI: "https://froxlor.example.com/api.php"}, owned: newRegistry()}
+			p.restoreState(tt.state)
+			assert.Empty(t, p.cache)
+			assert.Empty(t, p.owned.records)
+		})
+	}
+}
+
+func TestProcess_whenDryRunEnabled_shouldNotSaveState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traebeler-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store := state.NewFileStore(filepath.Join(dir, "froxlor.json"))
+
+	p := newStoredProcessor(store, &mockFroxlorHandler{})
+	p.dryRun = &dryRunHandler{}
+	assert.Nil(t, p.Process(context.Background(), toDomains("foo.bar")))
+
+	ok, err := store.Load(&persistedState{})
+	assert.Nil(t, err)
+	assert.False(t, ok, "planned changes should not be persisted")
+}
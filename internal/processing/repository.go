@@ -1,11 +1,13 @@
 package processing
 
 import (
+	"context"
+	"github.com/jenpet/traebeler/internal/domain"
 	"github.com/jenpet/traebeler/internal/processing/froxlor"
 )
 
 type processor interface {
-	Process(domains []string)
+	Process(ctx context.Context, domains []domain.Domain) error
 	ID() string
 	Init() error
 }
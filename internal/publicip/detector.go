@@ -0,0 +1,316 @@
+// Package publicip detects the public IP addresses of the host traebeler is running on. The addresses are queried
+// from a configurable chain of sources and are only accepted in case they are valid public IP literals.
+package publicip
+
+import (
+	"context"
+	"fmt"
+	"github.com/jenpet/traebeler/internal/log"
+	"github.com/jenpet/traebeler/internal/metrics"
+	"github.com/kelseyhightower/envconfig"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Strategies to combine the results of multiple sources.
+const (
+	// strategyFallback uses the first source returning a valid address
+	strategyFallback = "fallback"
+	// strategyConsensus queries all sources and uses the address reported by the majority of them
+	strategyConsensus = "consensus"
+)
+
+// family is the IP version of an address.
+type family int
+
+const (
+	familyV4 family = iota
+	familyV6
+)
+
+func (f family) String() string {
+	if f == familyV6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// Detector detects the public IPv4 and IPv6 address. In case none of the sources returns a valid address the last
+// known good address is returned as long as it is not older than the configured maximum age.
+type Detector struct {
+	sources  []source
+	strategy string
+	// families are the IP versions whose addresses are watched by Addresses
+	families []family
+	// allowPrivate accepts private addresses, e.g. of hosts which are only reachable within a private network
+	allowPrivate bool
+	maxAge       time.Duration
+	now          func() time.Time
+
+	mu        sync.Mutex
+	lastKnown map[family]detection
+}
+
+// detection is a successfully detected address and the time it was detected at.
+type detection struct {
+	ip string
+	at time.Time
+}
+
+// NewDetector returns a Detector configured by the TRAEBELER_IP_* environment variables.
+func NewDetector() (*Detector, error) {
+	var cfg config
+	if err := envconfig.Process("traebeler_ip", &cfg); err != nil {
+		return nil, err
+	}
+	return newDetector(cfg)
+}
+
+func newDetector(cfg config) (*Detector, error) {
+	if cfg.Strategy != strategyFallback && cfg.Strategy != strategyConsensus {
+		return nil, fmt.Errorf("TRAEBELER_IP_STRATEGY names an unknown IP detection strategy '%s', expected '%s' or '%s'", cfg.Strategy, strategyFallback, strategyConsensus)
+	}
+	if cfg.Timeout <= 0 {
+		return nil, fmt.Errorf("TRAEBELER_IP_TIMEOUT has to be greater than 0 but is %d", cfg.Timeout)
+	}
+	var sources []source
+	for _, name := range cfg.Sources {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		s, err := newSource(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("TRAEBELER_IP_SOURCES has to define at least one IP source")
+	}
+	var families []family
+	for _, raw := range cfg.Families {
+		switch strings.ToLower(strings.TrimSpace(raw)) {
+		case "":
+		case "ipv4":
+			families = append(families, familyV4)
+		case "ipv6":
+			families = append(families, familyV6)
+		default:
+			return nil, fmt.Errorf("unknown IP family '%s', expected 'ipv4' or 'ipv6'", raw)
+		}
+	}
+	return &Detector{
+		sources:      sources,
+		strategy:     cfg.Strategy,
+		families:     families,
+		allowPrivate: cfg.AllowPrivate,
+		maxAge:       time.Duration(cfg.LastKnownMaxAge) * time.Second,
+		now:          time.Now,
+		lastKnown:    map[family]detection{},
+	}, nil
+}
+
+// IPv4 returns the public IPv4 address. Pending queries of sources are aborted once the context is done.
+func (d *Detector) IPv4(ctx context.Context) (string, error) {
+	return d.detect(ctx, familyV4)
+}
+
+// IPv6 returns the public IPv6 address. Pending queries of sources are aborted once the context is done.
+func (d *Detector) IPv6(ctx context.Context) (string, error) {
+	return d.detect(ctx, familyV6)
+}
+
+// Addresses returns the public addresses of all watched IP versions. Addresses which are not watched or could not be
+// detected are empty.
+func (d *Detector) Addresses(ctx context.Context) (ipv4, ipv6 string) {
+	for _, f := range d.families {
+		ip, err := d.detect(ctx, f)
+		if err != nil {
+			log.Errorf("Failed to detect watched %s address. Error: %v", f, err)
+			continue
+		}
+		if f == familyV4 {
+			ipv4 = ip
+		} else {
+			ipv6 = ip
+		}
+	}
+	return
+}
+
+func (d *Detector) detect(ctx context.Context, f family) (string, error) {
+	var ip string
+	var err error
+	if d.strategy == strategyConsensus {
+		ip, err = d.consensus(ctx, f)
+	} else {
+		ip, err = d.fallback(ctx, f)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		d.lastKnown[f] = detection{ip: ip, at: d.now()}
+		metrics.SetPublicIP(f.String(), ip)
+		return ip, nil
+	}
+	last, ok := d.lastKnown[f]
+	if !ok {
+		return "", err
+	}
+	if d.maxAge > 0 && d.now().Sub(last.at) > d.maxAge {
+		return "", fmt.Errorf("%v, last known address '%s' is outdated", err, last.ip)
+	}
+	log.Errorf("Failed to detect %s address, using last known address '%s' detected at %s. Error: %v", f, last.ip, last.at.Format(time.RFC3339), err)
+	return last.ip, nil
+}
+
+// fallback queries the sources in their configured order and returns the first valid address. The remaining sources
+// are not queried anymore once the context is done.
+func (d *Detector) fallback(ctx context.Context, f family) (string, error) {
+	for _, s := range d.sources {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		ip, err := d.lookup(ctx, s, f)
+		if err != nil {
+			log.Errorf("IP source '%s' did not return a valid %s address. Error: %v", s.name(), f, err)
+			continue
+		}
+		return ip, nil
+	}
+	return "", fmt.Errorf("none of the %d IP sources returned a valid %s address", len(d.sources), f)
+}
+
+// consensus queries all sources concurrently and returns the address reported by more than half of them. Sources
+// failing or returning invalid addresses count as votes against every address.
+func (d *Detector) consensus(ctx context.Context, f family) (string, error) {
+	results := make([]string, len(d.sources))
+	var wg sync.WaitGroup
+	for i, s := range d.sources {
+		wg.Add(1)
+		go func(i int, s source) {
+			defer wg.Done()
+			ip, err := d.lookup(ctx, s, f)
+			if err != nil {
+				log.Errorf("IP source '%s' did not return a valid %s address. Error: %v", s.name(), f, err)
+				return
+			}
+			results[i] = ip
+		}(i, s)
+	}
+	wg.Wait()
+
+	votes := map[string]int{}
+	for _, ip := range results {
+		if ip != "" {
+			votes[ip]++
+		}
+	}
+	for ip, count := range votes {
+		if count*2 > len(d.sources) {
+			return ip, nil
+		}
+	}
+	return "", fmt.Errorf("IP sources did not agree on a %s address, votes: %v", f, votes)
+}
+
+// lookup queries the given source and validates that the result is a public address of the requested family.
+func (d *Detector) lookup(ctx context.Context, s source, f family) (string, error) {
+	ip, err := s.lookup(ctx, f)
+	if err != nil {
+		return "", err
+	}
+	if err := validatePublic(ip, f, d.allowPrivate); err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// privateNetworks are networks which are only routed within private networks.
+var privateNetworks = parseCIDRs(
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+)
+
+// reservedNetworks are networks which never address a host in any network.
+var reservedNetworks = parseCIDRs(
+	"0.0.0.0/8",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// validatePublic returns an error in case the given IP is not a public address of the given family. Private addresses
+// are only accepted in case it is allowed.
+func validatePublic(ip net.IP, f family, allowPrivate bool) error {
+	if ip == nil {
+		return fmt.Errorf("no IP address given")
+	}
+	if (f == familyV4) != (ip.To4() != nil) {
+		return fmt.Errorf("address '%s' is not an %s address", ip, f)
+	}
+	if network := containing(reservedNetworks, ip); network != nil {
+		return fmt.Errorf("address '%s' is not usable since it is part of %s", ip, network)
+	}
+	if network := containing(privateNetworks, ip); network != nil && !allowPrivate {
+		return fmt.Errorf("address '%s' is not public since it is part of %s", ip, network)
+	}
+	return nil
+}
+
+// containing returns the first of the given networks containing the IP or nil if there is none.
+func containing(networks []*net.IPNet, ip net.IP) *net.IPNet {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return network
+		}
+	}
+	return nil
+}
+
+type config struct {
+	// Sources is the ordered list of sources the addresses are queried from
+	Sources []string `default:"ipify,icanhazip,cloudflare"`
+	// Strategy defines how the results of the sources are combined, either "fallback" or "consensus"
+	Strategy string `default:"fallback"`
+	// Families are the IP versions whose addresses are watched for changes, "ipv4" and/or "ipv6"
+	Families []string `default:"ipv4"`
+	// Timeout of a single source query in seconds
+	Timeout int `default:"10"`
+	// AllowPrivate accepts private addresses as detection result
+	AllowPrivate bool `split_words:"true"`
+	// LastKnownMaxAge is the time in seconds the last known address is used in case detection fails, 0 for unlimited
+	LastKnownMaxAge int `split_words:"true" default:"3600"`
+	// Interface is the name of the network interface the "interface" source reads the address from
+	Interface string
+	// InterfaceCIDRs restricts the addresses of the interface to the given networks
+	InterfaceCIDRs []string `envconfig:"interface_cidrs"`
+	// InterfaceSkip* drop private, link-local and temporary IPv6 addresses of the interface
+	InterfaceSkipPrivate   bool `split_words:"true" default:"true"`
+	InterfaceSkipLinkLocal bool `split_words:"true" default:"true"`
+	InterfaceSkipTemporary bool `split_words:"true" default:"true"`
+}
@@ -0,0 +1,217 @@
+package publicip
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDetect_withFallbackStrategy_shouldReturnFirstValidAddress(t *testing.T) {
+	var fallbackTests = []struct {
+		name          string
+		sources       []source
+		expected      string
+		errorExpected bool
+	}{
+		{"first source wins", []source{staticSource("93.184.216.34"), staticSource("93.184.216.35")}, "93.184.216.34", false},
+		{"failing source is skipped", []source{failingSource{}, staticSource("93.184.216.35")}, "93.184.216.35", false},
+		{"private address is skipped", []source{staticSource("192.168.178.1"), staticSource("93.184.216.35")}, "93.184.216.35", false},
+		{"all sources fail", []source{failingSource{}, staticSource("127.0.0.1")}, "", true},
+	}
+	for _, tt := range fallbackTests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := testDetector(strategyFallback, tt.sources...)
+			ip, err := d.IPv4(context.Background())
+			assert.Equal(t, tt.errorExpected, err != nil, "error expected: '%t' but got '%v'", tt.errorExpected, err)
+			assert.Equal(t, tt.expected, ip)
+		})
+	}
+}
+
+func TestDetect_withConsensusStrategy_shouldReturnMajorityAddress(t *testing.T) {
+	var consensusTests = []struct {
+		name          string
+		sources       []source
+		expected      string
+		errorExpected bool
+	}{
+		{"all agree", []source{staticSource("93.184.216.34"), staticSource("93.184.216.34")}, "93.184.216.34", false},
+		{"majority agrees", []source{staticSource("93.184.216.34"), staticSource("93.184.216.35"), staticSource("93.184.216.34")}, "93.184.216.34", false},
+		{"failing source counts against", []source{staticSource("93.184.216.34"), failingSource{}}, "", true},
+		{"no majority", []source{staticSource("93.184.216.34"), staticSource("93.184.216.35"), failingSource{}}, "", true},
+		{"invalid addresses do not vote", []source{staticSource("10.0.0.1"), staticSource("10.0.0.1"), staticSource("93.184.216.34")}, "", true},
+	}
+	for _, tt := range consensusTests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := testDetector(strategyConsensus, tt.sources...)
+			ip, err := d.IPv4(context.Background())
+			assert.Equal(t, tt.errorExpected, err != nil, "error expected: '%t' but got '%v'", tt.errorExpected, err)
+			assert.Equal(t, tt.expected, ip)
+		})
+	}
+}
+
+func TestDetect_whenAllSourcesFail_shouldReturnLastKnownGoodAddress(t *testing.T) {
+	s := &toggleSource{ip: "93.184.216.34"}
+	d := testDetector(strategyFallback, s)
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	ip, err := d.IPv4(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "93.184.216.34", ip)
+
+	s.ip = "<html>error</html>"
+	now = now.Add(time.Minute)
+	ip, err = d.IPv4(context.Background())
+	assert.Nil(t, err, "the last known address should be used")
+	assert.Equal(t, "93.184.216.34", ip)
+
+	_, err = d.IPv6(context.Background())
+	assert.NotNil(t, err, "the last known address of another family must not be used")
+
+	now = now.Add(time.Hour)
+	_, err = d.IPv4(context.Background())
+	assert.NotNil(t, err, "an outdated last known address should not be used")
+}
+
+func TestValidatePublic(t *testing.T) {
+	var validationTests = []struct {
+		ip            string
+		f             family
+		errorExpected bool
+	}{
+		{"93.184.216.34", familyV4, false},
+		{"2606:2800:220:1:248:1893:25c8:1946", familyV6, false},
+		{"93.184.216.34", familyV6, true},
+		{"2606:2800:220:1:248:1893:25c8:1946", familyV4, true},
+		{"::ffff:93.184.216.34", familyV6, true},
+		{"10.1.2.3", familyV4, true},
+		{"172.16.0.1", familyV4, true},
+		{"192.168.178.1", familyV4, true},
+		{"100.64.0.1", familyV4, true},
+		{"127.0.0.1", familyV4, true},
+		{"169.254.1.1", familyV4, true},
+		{"0.0.0.0", familyV4, true},
+		{"::1", familyV6, true},
+		{"fd00::1", familyV6, true},
+		{"fe80::1", familyV6, true},
+	}
+	for _, tt := range validationTests {
+		t.Run(tt.ip, func(t *testing.T) {
+			err := validatePublic(net.ParseIP(tt.ip), tt.f, false)
+			assert.Equal(t, tt.errorExpected, err != nil, "error expected: '%t' but got '%v'", tt.errorExpected, err)
+		})
+	}
+}
+
+func TestNewDetector_withInvalidConfig_shouldFail(t *testing.T) {
+	var configTests = []struct {
+		name string
+		cfg  config
+		// expected is the error message, empty in case it is not checked
+		expected string
+	}{
+		{"unknown strategy", config{Sources: []string{"ipify"}, Strategy: "random", Timeout: 1}, "TRAEBELER_IP_STRATEGY names an unknown IP detection strategy 'random', expected 'fallback' or 'consensus'"},
+		{"unknown source", config{Sources: []string{"ipify", "foo"}, Strategy: strategyFallback, Timeout: 1}, ""},
+		{"no source", config{Sources: []string{" "}, Strategy: strategyFallback, Timeout: 1}, "TRAEBELER_IP_SOURCES has to define at least one IP source"},
+		{"no timeout", config{Sources: []string{"ipify"}, Strategy: strategyFallback}, "TRAEBELER_IP_TIMEOUT has to be greater than 0 but is 0"},
+		{"unknown family", config{Sources: []string{"ipify"}, Strategy: strategyFallback, Timeout: 1, Families: []string{"ipv5"}}, ""},
+	}
+	for _, tt := range configTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newDetector(tt.cfg)
+			assert.NotNil(t, err)
+			if tt.expected != "" {
+				assert.EqualError(t, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAddresses_shouldOnlyReturnWatchedAndDetectedAddresses(t *testing.T) {
+	d := testDetector(strategyFallback, &toggleSource{ip: "93.184.216.34"})
+	ipv4, ipv6 := d.Addresses(context.Background())
+	assert.Empty(t, ipv4, "unwatched addresses should not be detected")
+	assert.Empty(t, ipv6, "unwatched addresses should not be detected")
+
+	d.families = []family{familyV4, familyV6}
+	ipv4, ipv6 = d.Addresses(context.Background())
+	assert.Equal(t, "93.184.216.34", ipv4)
+	assert.Empty(t, ipv6, "addresses which could not be detected should be empty")
+}
+
+func testDetector(strategy string, sources ...source) *Detector {
+	return &Detector{
+		sources:   sources,
+		strategy:  strategy,
+		maxAge:    time.Hour,
+		now:       time.Now,
+		lastKnown: map[family]detection{},
+	}
+}
+
+func TestDetect_whenContextIsDone_shouldAbortLookups(t *testing.T) {
+	blocking := &blockingSource{}
+	d := testDetector(strategyFallback, blocking, staticSource("93.184.216.34"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	_, err := d.IPv4(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "the remaining sources should not be queried once the context is done")
+	assert.Equal(t, 1, blocking.lookups)
+
+	_, err = testDetector(strategyConsensus, blocking, blocking).IPv4(ctx)
+	assert.NotNil(t, err)
+}
+
+// staticSource always returns the given raw address for every family.
+type staticSource string
+
+func (s staticSource) name() string { return "static" }
+
+func (s staticSource) lookup(_ context.Context, _ family) (net.IP, error) {
+	return net.ParseIP(string(s)), nil
+}
+
+type failingSource struct{}
+
+func (s failingSource) name() string { return "failing" }
+
+func (s failingSource) lookup(_ context.Context, _ family) (net.IP, error) {
+	return nil, errors.New("source error")
+}
+
+// toggleSource returns its current raw address which can be changed in between lookups.
+type toggleSource struct {
+	ip string
+}
+
+func (s *toggleSource) name() string { return "toggle" }
+
+func (s *toggleSource) lookup(_ context.Context, f family) (net.IP, error) {
+	if f == familyV6 {
+		return nil, errors.New("no IPv6")
+	}
+	return net.ParseIP(s.ip), nil
+}
+
+// blockingSource blocks until its context is done.
+type blockingSource struct {
+	mu      sync.Mutex
+	lookups int
+}
+
+func (s *blockingSource) name() string { return "blocking" }
+
+func (s *blockingSource) lookup(ctx context.Context, _ family) (net.IP, error) {
+	s.mu.Lock()
+	s.lookups++
+	s.mu.Unlock()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
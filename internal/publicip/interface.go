@@ -0,0 +1,143 @@
+package publicip
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ifInet6 lists the IPv6 addresses of all interfaces together with their flags on Linux.
+const ifInet6 = "/proc/net/if_inet6"
+
+// ifaFlagTemporary flags temporary IPv6 addresses created by privacy extensions (IFA_F_TEMPORARY).
+const ifaFlagTemporary = 0x01
+
+// interfaceSource reads the address bound to a local network interface. This allows hosts which have a public
+// address bound directly and no outbound internet access to detect their address.
+type interfaceSource struct {
+	iface    string
+	networks []*net.IPNet
+	// skip private, link-local and temporary IPv6 addresses
+	skipPrivate, skipLinkLocal, skipTemporary bool
+	// addrs and temporary are replaceable to improve testing
+	addrs     func(iface string) ([]net.Addr, error)
+	temporary func() (map[string]bool, error)
+}
+
+func newInterfaceSource(cfg config) (source, error) {
+	if cfg.Interface == "" {
+		return nil, fmt.Errorf("IP source 'interface' requires an interface name")
+	}
+	var networks []*net.IPNet
+	for _, cidr := range cfg.InterfaceCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface CIDR '%s': %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return interfaceSource{
+		iface:         cfg.Interface,
+		networks:      networks,
+		skipPrivate:   cfg.InterfaceSkipPrivate,
+		skipLinkLocal: cfg.InterfaceSkipLinkLocal,
+		skipTemporary: cfg.InterfaceSkipTemporary,
+		addrs:         interfaceAddrs,
+		temporary:     temporaryAddrs,
+	}, nil
+}
+
+func (s interfaceSource) name() string {
+	return "interface"
+}
+
+// lookup returns the first address of the interface which belongs to the given family and passes all filters.
+func (s interfaceSource) lookup(_ context.Context, f family) (net.IP, error) {
+	addrs, err := s.addrs(s.iface)
+	if err != nil {
+		return nil, err
+	}
+	var temporary map[string]bool
+	if s.skipTemporary && f == familyV6 {
+		if temporary, err = s.temporary(); err != nil {
+			return nil, fmt.Errorf("failed to identify temporary addresses: %v", err)
+		}
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (f == familyV4) != (ipNet.IP.To4() != nil) {
+			continue
+		}
+		ip := ipNet.IP
+		switch {
+		case len(s.networks) > 0 && containing(s.networks, ip) == nil:
+		case s.skipLinkLocal && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()):
+		case s.skipPrivate && containing(privateNetworks, ip) != nil:
+		case temporary[ip.String()]:
+		default:
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("interface '%s' does not have a matching %s address", s.iface, f)
+}
+
+// interfaceAddrs returns the addresses of the interface with the given name.
+func interfaceAddrs(name string) ([]net.Addr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		if iface.Name == name {
+			return iface.Addrs()
+		}
+	}
+	return nil, fmt.Errorf("interface '%s' does not exist", name)
+}
+
+// temporaryAddrs returns the temporary IPv6 addresses of all interfaces. The standard library does not expose the
+// address flags, therefore they are read from procfs. On systems without procfs no address is considered temporary.
+func temporaryAddrs() (map[string]bool, error) {
+	file, err := os.Open(ifInet6)
+	if os.IsNotExist(err) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseIfInet6(bufio.NewScanner(file))
+}
+
+// parseIfInet6 parses lines like "20010db8000000000000000000000001 02 40 00 01 eth0" holding the address, interface
+// index, prefix length, scope, flags and interface name.
+func parseIfInet6(scanner *bufio.Scanner) (map[string]bool, error) {
+	temporary := map[string]bool{}
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[0])
+		if err != nil || len(raw) != net.IPv6len {
+			return nil, fmt.Errorf("invalid address '%s' in %s", fields[0], ifInet6)
+		}
+		flags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flags '%s' in %s", fields[4], ifInet6)
+		}
+		if flags&ifaFlagTemporary != 0 {
+			temporary[net.IP(raw).String()] = true
+		}
+	}
+	return temporary, scanner.Err()
+}
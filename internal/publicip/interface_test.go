@@ -0,0 +1,76 @@
+package publicip
+
+import (
+	"bufio"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestInterfaceSourceLookup(t *testing.T) {
+	addrs := []net.Addr{
+		ipNet("fe80::1/64"),
+		ipNet("192.168.178.2/24"),
+		ipNet("fd00::2/64"),
+		ipNet("2001:db8::abcd/64"),
+		ipNet("93.184.216.34/24"),
+		ipNet("2001:db8::2/64"),
+	}
+	var interfaceTests = []struct {
+		name          string
+		cfg           config
+		f             family
+		expected      string
+		errorExpected bool
+	}{
+		{"first address without filters", config{Interface: "eth0"}, familyV4, "192.168.178.2", false},
+		{"private addresses are skipped", config{Interface: "eth0", InterfaceSkipPrivate: true}, familyV4, "93.184.216.34", false},
+		{"link-local addresses are skipped", config{Interface: "eth0", InterfaceSkipLinkLocal: true}, familyV6, "fd00::2", false},
+		{"temporary addresses are skipped", config{Interface: "eth0", InterfaceSkipLinkLocal: true, InterfaceSkipPrivate: true, InterfaceSkipTemporary: true}, familyV6, "2001:db8::2", false},
+		{"addresses are filtered by CIDR", config{Interface: "eth0", InterfaceCIDRs: []string{"93.184.0.0/16"}}, familyV4, "93.184.216.34", false},
+		{"no matching address", config{Interface: "eth0", InterfaceCIDRs: []string{"198.51.100.0/24"}}, familyV4, "", true},
+	}
+	for _, tt := range interfaceTests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newInterfaceSource(tt.cfg)
+			assert.Nil(t, err)
+			is := s.(interfaceSource)
+			is.addrs = func(iface string) ([]net.Addr, error) { return addrs, nil }
+			is.temporary = func() (map[string]bool, error) { return map[string]bool{"2001:db8::abcd": true}, nil }
+			ip, err := is.lookup(context.Background(), tt.f)
+			assert.Equal(t, tt.errorExpected, err != nil, "error expected: '%t' but got '%v'", tt.errorExpected, err)
+			if !tt.errorExpected {
+				assert.Equal(t, tt.expected, ip.String())
+			}
+		})
+	}
+}
+
+func TestNewInterfaceSource_withInvalidConfig_shouldFail(t *testing.T) {
+	_, err := newInterfaceSource(config{})
+	assert.NotNil(t, err, "an interface name is required")
+	_, err = newInterfaceSource(config{Interface: "eth0", InterfaceCIDRs: []string{"foo"}})
+	assert.NotNil(t, err, "invalid CIDRs should be rejected")
+}
+
+func TestParseIfInet6_shouldReturnTemporaryAddresses(t *testing.T) {
+	content := `fe800000000000000000000000000001 02 40 20 80     eth0
+20010db8000000000000000000000002 02 40 00 00     eth0
+20010db800000000000000000000abcd 02 40 00 01     eth0
+00000000000000000000000000000001 01 80 10 80       lo
+`
+	temporary, err := parseIfInet6(bufio.NewScanner(strings.NewReader(content)))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"2001:db8::abcd": true}, temporary)
+}
+
+func ipNet(cidr string) *net.IPNet {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	network.IP = ip
+	return network
+}
@@ -0,0 +1,127 @@
+package publicip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxResponseSize limits the amount of bytes read from a source, echo services only return a few bytes.
+const maxResponseSize = 4096
+
+// source returns the IP address of the host as seen by a remote service. A lookup is aborted once the context is done.
+type source interface {
+	name() string
+	lookup(ctx context.Context, f family) (net.IP, error)
+}
+
+// httpSource queries an HTTP echo service. Services are queried via a family specific URL which is only reachable
+// via the respective IP version. An empty URL means the family is not supported.
+type httpSource struct {
+	id           string
+	urlV4, urlV6 string
+	parse        func(body []byte) (string, error)
+	client       *http.Client
+}
+
+// newSource returns the known source with the given name.
+func newSource(name string, cfg config) (source, error) {
+	if name == "interface" {
+		return newInterfaceSource(cfg)
+	}
+	s := httpSource{id: name, client: &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}}
+	switch name {
+	case "ipify":
+		s.urlV4, s.urlV6, s.parse = "https://api.ipify.org?format=text", "https://api6.ipify.org?format=text", parseText
+	case "icanhazip":
+		s.urlV4, s.urlV6, s.parse = "https://ipv4.icanhazip.com", "https://ipv6.icanhazip.com", parseText
+	case "cloudflare":
+		s.urlV4, s.urlV6, s.parse = "https://1.1.1.1/cdn-cgi/trace", "https://[2606:4700:4700::1111]/cdn-cgi/trace", parseTrace
+	case "ipinfo":
+		s.urlV4, s.parse = "https://ipinfo.io/json", parseJSON
+	default:
+		return nil, fmt.Errorf("unknown IP source '%s', expected one of ipify, icanhazip, cloudflare, ipinfo or interface", name)
+	}
+	return s, nil
+}
+
+func (s httpSource) name() string {
+	return s.id
+}
+
+func (s httpSource) lookup(ctx context.Context, f family) (net.IP, error) {
+	url := s.urlV4
+	if f == familyV6 {
+		url = s.urlV6
+	}
+	if url == "" {
+		return nil, fmt.Errorf("source does not support %s", f)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("'%s' responded with HTTP status code %d", url, resp.StatusCode)
+	}
+	raw, err := s.parse(body)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(raw)
+	if ip == nil {
+		return nil, fmt.Errorf("response of '%s' is not an IP literal: '%s'", url, truncate(raw, 50))
+	}
+	return ip, nil
+}
+
+// parseText parses plain text responses only holding the address, e.g. "203.0.113.1\n".
+func parseText(body []byte) (string, error) {
+	return strings.TrimSpace(string(body)), nil
+}
+
+// parseJSON parses JSON responses holding the address in the field "ip", e.g. {"ip": "203.0.113.1"}.
+func parseJSON(body []byte) (string, error) {
+	var resp struct {
+		IP string `json:"ip"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("failed parsing JSON response: %v", err)
+	}
+	return resp.IP, nil
+}
+
+// parseTrace parses key value responses of the cloudflare trace endpoint holding the address in the line "ip=".
+func parseTrace(body []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "ip=") {
+			return strings.TrimPrefix(line, "ip="), nil
+		}
+	}
+	return "", fmt.Errorf("trace response does not contain an ip line")
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return s[:length] + "..."
+}
@@ -0,0 +1,99 @@
+package publicip
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPSourceLookup(t *testing.T) {
+	defer gock.Off()
+	var lookupTests = []struct {
+		name          string
+		source        string
+		f             family
+		uri, path     string
+		reply         func(*gock.Response)
+		expected      string
+		errorExpected bool
+	}{
+		{
+			"ipify text response",
+			"ipify", familyV4, "https://api.ipify.org", "/",
+			func(r *gock.Response) { r.Status(http.StatusOK).BodyString("93.184.216.34") },
+			"93.184.216.34", false,
+		},
+		{
+			"ipify IPv6 text response",
+			"ipify", familyV6, "https://api6.ipify.org", "/",
+			func(r *gock.Response) { r.Status(http.StatusOK).BodyString("2606:2800:220:1:248:1893:25c8:1946") },
+			"2606:2800:220:1:248:1893:25c8:1946", false,
+		},
+		{
+			"icanhazip text response with newline",
+			"icanhazip", familyV4, "https://ipv4.icanhazip.com", "/",
+			func(r *gock.Response) { r.Status(http.StatusOK).BodyString("93.184.216.34\n") },
+			"93.184.216.34", false,
+		},
+		{
+			"cloudflare trace response",
+			"cloudflare", familyV4, "https://1.1.1.1", "/cdn-cgi/trace",
+			func(r *gock.Response) {
+				r.Status(http.StatusOK).BodyString("fl=123\nh=1.1.1.1\nip=93.184.216.34\nts=1\n")
+			},
+			"93.184.216.34", false,
+		},
+		{
+			"ipinfo JSON response",
+			"ipinfo", familyV4, "https://ipinfo.io", "/json",
+			func(r *gock.Response) { r.Status(http.StatusOK).BodyString(`{"ip": "93.184.216.34", "city": "Foo"}`) },
+			"93.184.216.34", false,
+		},
+		{
+			"ipinfo does not support IPv6",
+			"ipinfo", familyV6, "https://ipinfo.io", "/json",
+			func(r *gock.Response) { r.Status(http.StatusOK).BodyString(`{"ip": "93.184.216.34"}`) },
+			"", true,
+		},
+		{
+			"HTML error page",
+			"ipify", familyV4, "https://api.ipify.org", "/",
+			func(r *gock.Response) { r.Status(http.StatusOK).BodyString("<html><body>Bad Gateway</body></html>") },
+			"", true,
+		},
+		{
+			"empty body",
+			"ipify", familyV4, "https://api.ipify.org", "/",
+			func(r *gock.Response) { r.Status(http.StatusOK) },
+			"", true,
+		},
+		{
+			"error status code",
+			"ipify", familyV4, "https://api.ipify.org", "/",
+			func(r *gock.Response) { r.Status(http.StatusBadGateway).BodyString("93.184.216.34") },
+			"", true,
+		},
+		{
+			"failed query",
+			"ipify", familyV4, "https://api.ipify.org", "/",
+			func(r *gock.Response) { r.Error = errors.New("foo") },
+			"", true,
+		},
+	}
+	for _, tt := range lookupTests {
+		t.Run(tt.name, func(t *testing.T) {
+			gock.Clean()
+			gock.New(tt.uri).Get(tt.path).ReplyFunc(tt.reply)
+			s, err := newSource(tt.source, config{Timeout: 1})
+			assert.Nil(t, err)
+			ip, err := s.lookup(context.Background(), tt.f)
+			assert.Equal(t, tt.errorExpected, err != nil, "error expected: '%t' but got '%v'", tt.errorExpected, err)
+			if !tt.errorExpected {
+				assert.Equal(t, tt.expected, ip.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/jenpet/traebeler/internal/log"
+	"github.com/jenpet/traebeler/internal/metrics"
+	"time"
+)
+
+// runner decouples a single processor from the worker loop. Every processor is driven by its own goroutine, so a slow
+// or failing processor can't delay the others. A state which arrives while the processor is still busy replaces
+// any state that is pending already, hence a processor always continues with the most recent domains. Since changes
+// are computed against the last state the processor handled successfully, no change gets lost by replacing a state.
+type runner struct {
+	processor processor
+	pending   chan state
+	// processed is the last state the processor handled successfully
+	processed state
+	health    *health
+	// timeout limits a single run, 0 for no limit
+	timeout time.Duration
+	// done is closed once the runner stopped
+	done chan struct{}
+}
+
+func newRunner(p processor, timeout time.Duration) *runner {
+	return &runner{processor: p, pending: make(chan state, 1), timeout: timeout, done: make(chan struct{})}
+}
+
+// startRunners creates a runner for every processor and starts it. The runners stop once ctx gets cancelled, a run in
+// progress is only aborted once work gets cancelled. The outcome of every run is recorded in the optional health.
+func startRunners(ctx, work context.Context, processors []processor, h *health, timeout time.Duration) []*runner {
+	var runners []*runner
+	for _, p := range processors {
+		r := newRunner(p, timeout)
+		r.health = h
+		go r.run(ctx, work)
+		runners = append(runners, r)
+	}
+	return runners
+}
+
+// drain waits for the runs in progress to finish. Runs which did not finish within the grace period are aborted by
+// cancelling their work context.
+func drain(runners []*runner, grace time.Duration, abort context.CancelFunc) {
+	deadline := time.After(grace)
+	for _, r := range runners {
+		select {
+		case <-r.done:
+		case <-deadline:
+			log.Errorf("Processor '%s' did not finish within the grace period of %v. Aborting it.", r.processor.ID(), grace)
+			abort()
+			return
+		}
+	}
+	log.Info("All processors finished.")
+}
+
+// submit hands the state of a worker cycle to the runner without blocking the caller.
+func (r *runner) submit(s state) {
+	select {
+	case <-r.pending:
+		log.Infof("Processor '%s' is still busy. Replacing its pending domains with the latest ones.", r.processor.ID())
+	default:
+	}
+	r.pending <- s
+}
+
+func (r *runner) run(ctx, work context.Context) {
+	defer close(r.done)
+	for {
+		select {
+		case s := <-r.pending:
+			if ctx.Err() != nil {
+				return
+			}
+			_ = r.process(work, s)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// process forwards the domains to the processor and logs the outcome as well as the duration. Processors which work on
+// changes receive the changes since their last successful run. A panicking processor is recovered and its panic is
+// treated as a regular error. A run exceeding the timeout is aborted.
+func (r *runner) process(ctx context.Context, s state) (err error) {
+	start := time.Now()
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("processor panicked: %v", rec)
+		}
+		metrics.ObserveProcessorRun(r.processor.ID(), start, err)
+		if r.health != nil {
+			var failures []domain.RecordFailure
+			if fr, ok := r.processor.(failureReporter); ok {
+				failures = fr.Failures()
+			}
+			var skipped []string
+			if sr, ok := r.processor.(skipReporter); ok {
+				skipped = sr.Skipped()
+			}
+			r.health.processorDone(r.processor.ID(), err, failures, skipped)
+		}
+		if err != nil {
+			log.Errorf("Processor '%s' failed processing %d domains after %v. Error: %v", r.processor.ID(), len(s.domains), time.Since(start), err)
+			return
+		}
+		r.processed = s
+		log.Infof("Processor '%s' processed %d domains in %v.", r.processor.ID(), len(s.domains), time.Since(start))
+	}()
+	if cp, ok := r.processor.(changeProcessor); ok {
+		return cp.ProcessChanges(ctx, diff(r.processed, s))
+	}
+	return r.processor.Process(ctx, s.domains)
+}
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRunnerProcess_whenProcessorPanics_shouldReturnError(t *testing.T) {
+	r := newRunner(&funcProcessor{fn: func(domains []domain.Domain) error {
+		panic("boom")
+	}}, 0)
+	err := r.process(context.Background(), state{domains: toDomains("foo.bar")})
+	assert.NotNil(t, err, "a panicking processor should result in an error")
+}
+
+func TestRunnerProcess_shouldReturnProcessorError(t *testing.T) {
+	r := newRunner(&funcProcessor{fn: func(domains []domain.Domain) error {
+		return errors.New("processor error")
+	}}, 0)
+	assert.EqualError(t, r.process(context.Background(), state{domains: toDomains("foo.bar")}), "processor error")
+}
+
+func TestRunners_whenOneProcessorIsSlow_shouldNotBlockOthers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan bool)
+	defer close(release)
+	slow := &funcProcessor{fn: func(domains []domain.Domain) error {
+		<-release
+		return nil
+	}}
+	fastCalls := make(chan []domain.Domain, 3)
+	fast := &funcProcessor{fn: func(domains []domain.Domain) error {
+		fastCalls <- domains
+		return nil
+	}}
+
+	runners := startRunners(ctx, ctx, []processor{slow, fast}, nil, 0)
+	for i := 0; i < 3; i++ {
+		for _, r := range runners {
+			r.submit(state{domains: toDomains("foo.bar")})
+		}
+		select {
+		case <-fastCalls:
+		case <-time.After(time.Second * 1):
+			assert.Fail(t, "fast processor was blocked by the slow one")
+			return
+		}
+	}
+}
+
+func TestRunnerSubmit_whenProcessorIsBusy_shouldOnlyKeepLatestDomains(t *testing.T) {
+	r := newRunner(&funcProcessor{}, 0)
+	r.submit(state{domains: toDomains("old.foo.bar")})
+	r.submit(state{domains: toDomains("new.foo.bar")})
+	assert.Len(t, r.pending, 1, "only a single domain set should be pending")
+	assert.Equal(t, toDomains("new.foo.bar"), (<-r.pending).domains, "the latest domain set should be pending")
+}
+
+func TestRunnerProcess_withChangeProcessor_shouldHandChangesSinceLastSuccessfulRun(t *testing.T) {
+	var received []domain.ChangeSet
+	fail := false
+	cp := &changeRecorder{fn: func(changes domain.ChangeSet) error {
+		received = append(received, changes)
+		if fail {
+			return errors.New("processor error")
+		}
+		return nil
+	}}
+	r := newRunner(cp, 0)
+
+	assert.Nil(t, r.process(context.Background(), state{domains: toDomains("foo.bar", "old.foo.bar"), ipv4: "93.184.216.34"}))
+	assert.Equal(t, toDomains("foo.bar", "old.foo.bar"), received[0].Added, "initially every domain should be added")
+	assert.True(t, received[0].IPChanged, "initially the address should be changed")
+
+	fail = true
+	assert.NotNil(t, r.process(context.Background(), state{domains: toDomains("foo.bar", "new.foo.bar"), ipv4: "93.184.216.35"}))
+	fail = false
+	assert.Nil(t, r.process(context.Background(), state{domains: toDomains("foo.bar", "new.foo.bar"), ipv4: "93.184.216.35"}))
+	changes := received[2]
+	assert.Equal(t, toDomains("new.foo.bar"), changes.Added, "changes of a failed run should be handed again")
+	assert.Equal(t, toDomains("old.foo.bar"), changes.Removed, "changes of a failed run should be handed again")
+	assert.Equal(t, toDomains("foo.bar"), changes.Unchanged)
+	assert.Equal(t, toDomains("foo.bar", "new.foo.bar"), changes.Domains, "the full list should be handed as well")
+	assert.True(t, changes.IPChanged, "changes of a failed run should be handed again")
+
+	assert.Nil(t, r.process(context.Background(), state{domains: toDomains("foo.bar", "new.foo.bar"), ipv4: "93.184.216.35"}))
+	assert.True(t, received[3].Empty(), "nothing should be changed after a successful run")
+}
+
+func TestRunnerProcess_whenTimeoutExceeded_shouldAbortProcessor(t *testing.T) {
+	r := newRunner(&blockingProcessor{}, time.Millisecond*10)
+	assert.Equal(t, context.DeadlineExceeded, r.process(context.Background(), state{domains: toDomains("foo.bar")}))
+}
+
+func TestDrain_shouldWaitForRunsInProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	work, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	started := make(chan bool)
+	release := make(chan bool)
+	finished := false
+	slow := &funcProcessor{fn: func(domains []domain.Domain) error {
+		started <- true
+		<-release
+		finished = true
+		return nil
+	}}
+	runners := startRunners(ctx, work, []processor{slow}, nil, 0)
+	runners[0].submit(state{domains: toDomains("foo.bar")})
+	<-started
+	cancel()
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		close(release)
+	}()
+
+	drain(runners, time.Second, abort)
+	assert.True(t, finished, "the run in progress should have finished")
+	assert.Nil(t, work.Err(), "the run in progress should not be aborted")
+}
+
+func TestDrain_whenGracePeriodExceeded_shouldAbortRunsInProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	work, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	bp := &blockingProcessor{started: make(chan bool, 1)}
+	runners := startRunners(ctx, work, []processor{bp}, nil, 0)
+	runners[0].submit(state{domains: toDomains("foo.bar")})
+	<-bp.started
+	cancel()
+
+	drain(runners, time.Millisecond*10, abort)
+	assert.Equal(t, context.Canceled, work.Err(), "the run in progress should be aborted")
+	select {
+	case <-runners[0].done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "the aborted runner should stop")
+	}
+}
+
+type changeRecorder struct {
+	funcProcessor
+	fn func(changes domain.ChangeSet) error
+}
+
+func (cr *changeRecorder) ProcessChanges(_ context.Context, changes domain.ChangeSet) error {
+	return cr.fn(changes)
+}
+
+type funcProcessor struct {
+	fn func(domains []domain.Domain) error
+}
+
+func (fp *funcProcessor) Process(_ context.Context, domains []domain.Domain) error {
+	if fp.fn != nil {
+		return fp.fn(domains)
+	}
+	return nil
+}
+
+func (fp *funcProcessor) ID() string {
+	return "func-processor"
+}
+
+// blockingProcessor blocks until its context is done.
+type blockingProcessor struct {
+	funcProcessor
+	started chan bool
+}
+
+func (bp *blockingProcessor) Process(ctx context.Context, _ []domain.Domain) error {
+	if bp.started != nil {
+		bp.started <- true
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
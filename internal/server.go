@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"context"
+	"github.com/jenpet/traebeler/internal/log"
+	"github.com/jenpet/traebeler/internal/metrics"
+	"net/http"
+	"time"
+)
+
+// newMux returns the handler of all HTTP endpoints of traebeler.
+func newMux(h *health) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", h.liveness)
+	mux.HandleFunc("/readyz", h.readiness)
+	return mux
+}
+
+// serve exposes the HTTP endpoints on the given address until the context gets cancelled. An empty address disables
+// the server. Since the endpoints are not essential for publishing domains a failing server is only logged.
+func serve(ctx context.Context, address string, handler http.Handler) {
+	if address == "" {
+		log.Info("HTTP server is disabled.")
+		return
+	}
+	server := &http.Server{Addr: address, Handler: handler}
+	go func() {
+		log.Infof("Serving HTTP endpoints on '%s'.", address)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("HTTP server on '%s' failed. Error: %v", address, err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+}
@@ -0,0 +1,23 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMux_shouldServeMetrics(t *testing.T) {
+	server := httptest.NewServer(newMux(newHealth(time.Minute)))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/metrics")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, string(body), "traebeler_deletion_guard_blocked")
+}
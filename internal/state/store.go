@@ -0,0 +1,104 @@
+// Package state persists the state of processors across restarts of traebeler, e.g. the records they manage.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kelseyhightower/envconfig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Store loads and saves the state of a single processor.
+type Store interface {
+	// Load decodes the saved state into v. The returned bool is false in case no state was saved yet, v is left
+	// untouched then.
+	Load(v interface{}) (bool, error)
+	// Save replaces the saved state with v. A failed save leaves the previous state intact.
+	Save(v interface{}) error
+}
+
+type config struct {
+	// Backend is the store the state is persisted in, either "file" or "none"
+	Backend string `default:"none"`
+	// Dir is the directory the state files are written to by the file backend
+	Dir string `default:"/var/lib/traebeler"`
+}
+
+// NewStore returns the store of the state with the given name configured by the TRAEBELER_STATE_* environment
+// variables. nil is returned in case persistence is disabled.
+func NewStore(name string) (Store, error) {
+	var cfg config
+	if err := envconfig.Process("traebeler_state", &cfg); err != nil {
+		return nil, err
+	}
+	switch cfg.Backend {
+	case "none":
+		return nil, nil
+	case "file":
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("state directory must not be empty")
+		}
+		return NewFileStore(filepath.Join(cfg.Dir, name+".json")), nil
+	default:
+		return nil, fmt.Errorf("unknown state backend '%s', expected 'file' or 'none'", cfg.Backend)
+	}
+}
+
+// FileStore stores the state as JSON document in a single file, e.g. on a volume. The file is replaced atomically,
+// hence a crash while saving never leaves a partially written state behind.
+type FileStore struct {
+	path string
+}
+
+// NewFileStore returns a store which persists the state in the file at the given path. Missing directories are created
+// on the first save.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load decodes the state file into v.
+func (fs *FileStore) Load(v interface{}) (bool, error) {
+	b, err := ioutil.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return false, fmt.Errorf("state file '%s' is corrupt: %v", fs.path, err)
+	}
+	return true, nil
+}
+
+// Save writes v to a temporary file next to the state file which replaces the state file once it is synced to disk.
+func (fs *FileStore) Save(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(fs.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(fs.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// the temporary file is gone after a successful rename
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path)
+}
@@ -0,0 +1,75 @@
+package state
+
+import (
+	"github.com/jenpet/traebeler/internal/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testState struct {
+	Version int      `json:"version"`
+	Records []string `json:"records"`
+}
+
+func TestFileStore_shouldSaveAndLoadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traebeler-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fs := NewFileStore(filepath.Join(dir, "nested", "froxlor.json"))
+
+	var loaded testState
+	ok, err := fs.Load(&loaded)
+	assert.Nil(t, err, "a missing state should not be an error")
+	assert.False(t, ok)
+
+	assert.Nil(t, fs.Save(testState{Version: 1, Records: []string{"foo.bar"}}))
+	assert.Nil(t, fs.Save(testState{Version: 1, Records: []string{"foo.bar", "sub.foo.bar"}}))
+	ok, err = fs.Load(&loaded)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, testState{Version: 1, Records: []string{"foo.bar", "sub.foo.bar"}}, loaded)
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "nested"))
+	assert.Nil(t, err)
+	assert.Len(t, files, 1, "temporary files should be removed after saving")
+}
+
+func TestFileStore_whenStateIsCorrupt_shouldReturnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traebeler-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "froxlor.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"version": 1, "rec`), 0644))
+
+	ok, err := NewFileStore(path).Load(&testState{})
+	assert.NotNil(t, err)
+	assert.False(t, ok)
+}
+
+func TestNewStore(t *testing.T) {
+	var storeTests = []struct {
+		name    string
+		envs    map[string]string
+		enabled bool
+		err     bool
+	}{
+		{"disabled by default", map[string]string{}, false, false},
+		{"file backend", map[string]string{"TRAEBELER_STATE_BACKEND": "file", "TRAEBELER_STATE_DIR": "/data"}, true, false},
+		{"file backend without directory", map[string]string{"TRAEBELER_STATE_BACKEND": "file", "TRAEBELER_STATE_DIR": ""}, false, true},
+		{"unknown backend", map[string]string{"TRAEBELER_STATE_BACKEND": "bolt"}, false, true},
+	}
+	for _, tt := range storeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer test.ClearEnvs(test.SetEnvs(tt.envs))
+			store, err := NewStore("froxlor")
+			assert.Equal(t, tt.err, err != nil)
+			assert.Equal(t, tt.enabled, store != nil)
+			if tt.enabled {
+				assert.Equal(t, "/data/froxlor.json", store.(*FileStore).path)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+// Package target resolves the addresses domains are pointed to in case they should not point to the detected public
+// address, e.g. since they are served on a different virtual IP.
+package target
+
+import (
+	"fmt"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/kelseyhightower/envconfig"
+	"net"
+	"path"
+	"strings"
+)
+
+// Family is the IP version of a target address.
+type Family int
+
+const (
+	IPv4 Family = iota
+	IPv6
+)
+
+func (f Family) String() string {
+	if f == IPv6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// Resolver resolves the configured target address of a domain. Overrides for a domain take precedence over the
+// address of its entrypoint which takes precedence over the static address of the family. Domains without any
+// configured address have to point to the detected one. The zero value does not resolve any domain.
+type Resolver struct {
+	static      map[Family]string
+	overrides   []override
+	entryPoints []entryPoint
+}
+
+// auto is the address of entrypoints which are served on the static or detected address.
+const auto = "auto"
+
+// entryPoint points all domains served on it to a fixed address or the static or detected address in case of auto.
+type entryPoint struct {
+	name   string
+	ip     string
+	family Family
+}
+
+// override points all domains matching a pattern to a fixed address.
+type override struct {
+	pattern string
+	ip      string
+	family  Family
+}
+
+// exact returns true in case the pattern does not hold any glob characters and therefore matches a single domain.
+func (o override) exact() bool {
+	return !strings.ContainsAny(o.pattern, `*?[\`)
+}
+
+// NewResolver returns a Resolver configured by the TRAEBELER_TARGET_* environment variables.
+func NewResolver() (*Resolver, error) {
+	var cfg config
+	if err := envconfig.Process("traebeler_target", &cfg); err != nil {
+		return nil, err
+	}
+	return newResolver(cfg)
+}
+
+func newResolver(cfg config) (*Resolver, error) {
+	r := &Resolver{static: map[Family]string{}}
+	for f, raw := range map[Family]string{IPv4: cfg.IPv4, IPv6: cfg.IPv6} {
+		if raw == "" {
+			continue
+		}
+		ip, family, err := parseIP(raw)
+		if err != nil {
+			return nil, err
+		}
+		if family != f {
+			return nil, fmt.Errorf("static %s target '%s' is an %s address", f, raw, family)
+		}
+		r.static[f] = ip
+	}
+	for _, raw := range cfg.Overrides {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		parts := strings.SplitN(raw, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid target override '%s', expected '<domain>=<ip>'", raw)
+		}
+		o := override{pattern: strings.ToLower(strings.TrimSpace(parts[0]))}
+		if _, err := path.Match(o.pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid target override pattern '%s': %v", o.pattern, err)
+		}
+		var err error
+		if o.ip, o.family, err = parseIP(parts[1]); err != nil {
+			return nil, err
+		}
+		r.overrides = append(r.overrides, o)
+	}
+	for _, raw := range cfg.EntryPoints {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		parts := strings.SplitN(raw, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid entrypoint target '%s', expected '<entrypoint>=<ip>' or '<entrypoint>=%s'", raw, auto)
+		}
+		e := entryPoint{name: strings.TrimSpace(parts[0])}
+		if strings.TrimSpace(parts[1]) == auto {
+			// auto applies to both families, therefore it is added once per family
+			r.entryPoints = append(r.entryPoints, entryPoint{name: e.name, family: IPv4}, entryPoint{name: e.name, family: IPv6})
+			continue
+		}
+		var err error
+		if e.ip, e.family, err = parseIP(parts[1]); err != nil {
+			return nil, err
+		}
+		r.entryPoints = append(r.entryPoints, e)
+	}
+	return r, nil
+}
+
+func parseIP(raw string) (string, Family, error) {
+	ip := net.ParseIP(strings.TrimSpace(raw))
+	if ip == nil {
+		return "", IPv4, fmt.Errorf("target '%s' is not an IP address", raw)
+	}
+	if ip.To4() != nil {
+		return ip.String(), IPv4, nil
+	}
+	return ip.String(), IPv6, nil
+}
+
+// Resolve returns the configured address of the given family for a domain. The returned bool is false in case no
+// address is configured and the domain has to point to the detected address. An override matching the domain exactly
+// takes precedence over glob overrides which are matched in their configured order. Domains served on multiple mapped
+// entrypoints point to the address of the entrypoint which was configured first.
+func (r *Resolver) Resolve(d domain.Domain, f Family) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	if ip, ok := r.override(strings.ToLower(d.Name), f); ok {
+		return ip, true
+	}
+	for _, e := range r.entryPoints {
+		if e.family != f || !contains(d.EntryPoints, e.name) {
+			continue
+		}
+		if e.ip != "" {
+			return e.ip, true
+		}
+		break
+	}
+	ip, ok := r.static[f]
+	return ip, ok
+}
+
+// override returns the address of the override matching the domain.
+func (r *Resolver) override(domain string, f Family) (string, bool) {
+	var glob *override
+	for i, o := range r.overrides {
+		if o.family != f {
+			continue
+		}
+		if o.exact() {
+			if o.pattern == domain {
+				return o.ip, true
+			}
+			continue
+		}
+		if matched, _ := path.Match(o.pattern, domain); matched && glob == nil {
+			glob = &r.overrides[i]
+		}
+	}
+	if glob != nil {
+		return glob.ip, true
+	}
+	return "", false
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, element := range haystack {
+		if element == needle {
+			return true
+		}
+	}
+	return false
+}
+
+type config struct {
+	// IPv4 and IPv6 are static addresses all domains are pointed to instead of the detected ones
+	IPv4 string `envconfig:"ipv4"`
+	IPv6 string `envconfig:"ipv6"`
+	// Overrides point domains matching a glob to an address, e.g. "*.lan.example.com=10.0.0.5"
+	Overrides []string
+	// EntryPoints point domains served on an entrypoint to an address, e.g. "web-lan=10.0.0.5,web-public=auto"
+	EntryPoints []string `envconfig:"entrypoints"`
+}
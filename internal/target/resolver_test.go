@@ -0,0 +1,101 @@
+package target
+
+import (
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	r, err := newResolver(config{
+		IPv4: "203.0.113.1",
+		Overrides: []string{
+			"*.lan.example.com=10.0.0.5",
+			"*.example.com=10.0.0.6",
+			"db.lan.example.com=10.0.0.7",
+			"*.lan.example.com=fd00::5",
+		},
+	})
+	assert.Nil(t, err)
+	var resolveTests = []struct {
+		name       string
+		domain     string
+		family     Family
+		expected   string
+		configured bool
+	}{
+		{"first matching glob wins", "nas.lan.example.com", IPv4, "10.0.0.5", true},
+		{"exact match takes precedence", "db.lan.example.com", IPv4, "10.0.0.7", true},
+		{"domains are matched case insensitive", "NAS.lan.example.com", IPv4, "10.0.0.5", true},
+		{"static address as fallback", "foo.bar", IPv4, "203.0.113.1", true},
+		{"overrides are family specific", "nas.lan.example.com", IPv6, "fd00::5", true},
+		{"no address configured", "foo.bar", IPv6, "", false},
+	}
+	for _, tt := range resolveTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, ok := r.Resolve(domain.Domain{Name: tt.domain}, tt.family)
+			assert.Equal(t, tt.configured, ok)
+			assert.Equal(t, tt.expected, ip)
+		})
+	}
+}
+
+func TestResolve_whenResolverIsNil_shouldNotResolveAnything(t *testing.T) {
+	var r *Resolver
+	_, ok := r.Resolve(domain.Domain{Name: "foo.bar"}, IPv4)
+	assert.False(t, ok)
+}
+
+func TestNewResolver_withInvalidConfig_shouldFail(t *testing.T) {
+	var configTests = []struct {
+		name string
+		cfg  config
+	}{
+		{"invalid static address", config{IPv4: "foo"}},
+		{"static address of wrong family", config{IPv6: "203.0.113.1"}},
+		{"override without address", config{Overrides: []string{"foo.bar"}}},
+		{"override without domain", config{Overrides: []string{"=203.0.113.1"}}},
+		{"override with invalid address", config{Overrides: []string{"foo.bar=foo"}}},
+		{"override with invalid glob", config{Overrides: []string{"[foo.bar=203.0.113.1"}}},
+		{"entrypoint without address", config{EntryPoints: []string{"web-lan"}}},
+		{"entrypoint with invalid address", config{EntryPoints: []string{"web-lan=foo"}}},
+	}
+	for _, tt := range configTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newResolver(tt.cfg)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestResolve_withEntryPoints_shouldUseAddressOfFirstConfiguredEntryPoint(t *testing.T) {
+	r, err := newResolver(config{
+		IPv4:        "203.0.113.1",
+		Overrides:   []string{"nas.foo.bar=10.0.0.9"},
+		EntryPoints: []string{"web-public=auto", "web-lan=10.0.0.5", "web-lan=fd00::5", "web-dmz=192.168.1.5"},
+	})
+	assert.Nil(t, err)
+	var entryPointTests = []struct {
+		name        string
+		entryPoints []string
+		domain      string
+		family      Family
+		expected    string
+		configured  bool
+	}{
+		{"address of entrypoint", []string{"web-lan"}, "wiki.foo.bar", IPv4, "10.0.0.5", true},
+		{"address of entrypoint for IPv6", []string{"web-lan"}, "wiki.foo.bar", IPv6, "fd00::5", true},
+		{"first configured entrypoint wins", []string{"web-dmz", "web-lan"}, "wiki.foo.bar", IPv4, "10.0.0.5", true},
+		{"auto entrypoint falls back to static address", []string{"web-lan", "web-public"}, "wiki.foo.bar", IPv4, "203.0.113.1", true},
+		{"auto entrypoint falls back to detection", []string{"web-public", "web-lan"}, "wiki.foo.bar", IPv6, "", false},
+		{"unmapped entrypoint", []string{"websecure"}, "wiki.foo.bar", IPv4, "203.0.113.1", true},
+		{"domain override takes precedence", []string{"web-lan"}, "nas.foo.bar", IPv4, "10.0.0.9", true},
+	}
+	for _, tt := range entryPointTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, ok := r.Resolve(domain.Domain{Name: tt.domain, EntryPoints: tt.entryPoints}, tt.family)
+			assert.Equal(t, tt.configured, ok)
+			assert.Equal(t, tt.expected, ip)
+		})
+	}
+}
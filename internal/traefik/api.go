@@ -1,74 +1,218 @@
 package traefik
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/jenpet/traebeler/internal/domain"
 	"github.com/jenpet/traebeler/internal/log"
+	"github.com/jenpet/traebeler/internal/metrics"
 	"github.com/kelseyhightower/envconfig"
 	traefik "github.com/traefik/traefik/v2/pkg/config/runtime"
-	"github.com/traefik/traefik/v2/pkg/rules"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func Provider() traefikAPI {
+	provider, err := NewProvider()
+	if err != nil {
+		log.Panicf("Failed loading traefik configuration. Error: %v", err)
+	}
+	return provider
+}
+
+// NewProvider returns a provider configured by the TRAEFIK_* environment variables without contacting traefik.
+func NewProvider() (traefikAPI, error) {
 	var cfg traefikConfig
-	err := envconfig.Process("traefik", &cfg)
+	if err := envconfig.Process("traefik", &cfg); err != nil {
+		return traefikAPI{}, err
+	}
+	filter, err := newRouterFilter(cfg)
 	if err != nil {
-		log.Panic("Failed loading traefik configuration.")
+		return traefikAPI{}, fmt.Errorf("invalid router filters: %v", err)
 	}
-	return traefikAPI{baseURI: cfg.BaseURI}
+	return traefikAPI{baseURI: cfg.BaseURI, http: cfg.HTTPEnabled, tcp: cfg.TCPEnabled, filter: filter}, nil
 }
 
 // GetDomains queries the traefik API for all of its routers and their respective rules
 // to return an effective list of domains as strings. All routers which are enabled will be used for domain extraction.
-func GetDomains(baseURI string) []string {
-	return retrieveDomains(traefikAPI{baseURI: baseURI}.getRouters)
+// An error is returned in case the traefik API could not be queried successfully.
+func GetDomains(ctx context.Context, baseURI string) ([]string, error) {
+	domains, err := retrieveDomains(ctx, traefikAPI{baseURI: baseURI, http: true}.getRouters, routerFilter{})
+	if err != nil {
+		return nil, err
+	}
+	return domain.Names(domains), nil
+}
+
+type listRouters func(ctx context.Context) ([]router, error)
+
+// router is the protocol independent representation of a HTTP or TCP router as it is returned by the traefik API.
+// Besides the runtime information the API adds the router's name and provider. Since traefik v3 routers additionally
+// report the syntax of their rule.
+type router struct {
+	Name        string     `json:"name,omitempty"`
+	Provider    string     `json:"provider,omitempty"`
+	EntryPoints []string   `json:"entryPoints,omitempty"`
+	Service     string     `json:"service,omitempty"`
+	Priority    int64      `json:"priority,omitempty"`
+	Rule        string     `json:"rule,omitempty"`
+	RuleSyntax  string     `json:"ruleSyntax,omitempty"`
+	TLS         *routerTLS `json:"tls,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	Err         []string   `json:"error,omitempty"`
+	// protocol is not part of the response but set depending on the queried endpoint
+	protocol string
+}
+
+// routerTLS is the TLS configuration of a HTTP or TCP router. Only TCP routers are able to pass TLS through.
+type routerTLS struct {
+	CertResolver string `json:"certResolver,omitempty"`
+	Passthrough  bool   `json:"passthrough,omitempty"`
+}
+
+// provider returns the provider of the router which is either reported explicitly or part of the router's name.
+func (r router) provider() string {
+	if r.Provider != "" {
+		return r.Provider
+	}
+	if i := strings.LastIndex(r.Name, "@"); i >= 0 {
+		return r.Name[i+1:]
+	}
+	return ""
+}
+
+// descriptor returns the router as source of a domain with the given accepted entrypoints.
+func (r router) descriptor(entryPoints []string) domain.Router {
+	d := domain.Router{
+		Name:        r.Name,
+		Provider:    r.provider(),
+		Protocol:    r.protocol,
+		Service:     r.Service,
+		EntryPoints: entryPoints,
+	}
+	if r.TLS != nil {
+		d.TLS = &domain.TLS{CertResolver: r.TLS.CertResolver, Passthrough: r.TLS.Passthrough}
+	}
+	return d
 }
 
-type listRouters func() ([]traefik.RouterInfo, error)
+// metadata returns the "service" and "priority" of the router in case they are set. They are attached to the domains
+// of the router, a domain served by multiple routers keeps the values of the first one.
+func (r router) metadata() map[string]string {
+	metadata := map[string]string{}
+	if r.Service != "" {
+		metadata["service"] = r.Service
+	}
+	if r.Priority != 0 {
+		metadata["priority"] = strconv.FormatInt(r.Priority, 10)
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
 
 type traefikAPI struct {
 	baseURI string
+	// http and tcp switch the domain extraction of the respective router protocol on or off
+	http, tcp bool
+	filter    routerFilter
 }
 
-func (ta traefikAPI) GetDomains() []string {
-	return retrieveDomains(ta.getRouters)
+// GetDomains returns the unique domains of all enabled routers of the enabled protocols together with the entrypoints
+// they are served on. In case any of the protocols could not be queried an error is returned, since a partial result
+// would look like removed domains. Pending requests are aborted once the context is done.
+func (ta traefikAPI) GetDomains(ctx context.Context) ([]domain.Domain, error) {
+	var domains []domain.Domain
+	if ta.http {
+		httpDomains, err := retrieveDomains(ctx, ta.getRouters, ta.filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed retrieving HTTP routers: %v", err)
+		}
+		domains = mergeDomains(domains, httpDomains...)
+	}
+	if ta.tcp {
+		tcpDomains, err := retrieveDomains(ctx, ta.getTCPRouters, ta.filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed retrieving TCP routers: %v", err)
+		}
+		domains = mergeDomains(domains, tcpDomains...)
+	}
+	return domains, nil
 }
 
 // improve testing
-func (ta traefikAPI) getRouters() (routerInfos []traefik.RouterInfo, err error) {
-	uri := fmt.Sprintf("%v/api/http/routers", ta.baseURI)
-	res, err := http.Get(uri)
+func (ta traefikAPI) getRouters(ctx context.Context) (routers []router, err error) {
+	err = ta.get(ctx, "/api/http/routers", &routers)
+	setProtocol(routers, "http")
+	log.Debugf("Received %v listRouters from traefik.", len(routers))
+	return
+}
+
+func (ta traefikAPI) getTCPRouters(ctx context.Context) (routers []router, err error) {
+	err = ta.get(ctx, "/api/tcp/routers", &routers)
+	setProtocol(routers, "tcp")
+	log.Debugf("Received %v TCP routers from traefik.", len(routers))
+	return
+}
+
+func setProtocol(routers []router, protocol string) {
+	for i := range routers {
+		routers[i].protocol = protocol
+	}
+}
+
+// get queries the given path of the traefik API and converts the JSON response into v.
+func (ta traefikAPI) get(ctx context.Context, path string, v interface{}) (err error) {
+	defer func(start time.Time) {
+		metrics.ObserveRequest("traefik", path, start, err)
+	}(time.Now())
+	uri := fmt.Sprintf("%v%v", ta.baseURI, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Errorf("Failed to communicate with traefik API on destination '%v'. Error: %v", ta.baseURI, err)
-		return
+		return err
 	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		log.Errorf("Failed to parse traefik response body into byte array. Error: %v", err)
-		return
+		return err
 	}
 
-	err = json.Unmarshal(body, &routerInfos)
-	if err != nil {
-		log.Errorf("Failed to convert traefik response in RouterInfo list. Error: %v", err)
-		return
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		err = fmt.Errorf("traefik API responded to '%v' with HTTP status code %d", path, res.StatusCode)
+		log.Errorf("Failed to query traefik API. Error: %v. Body: %s", err, truncate(string(body), 200))
+		return err
 	}
 
-	log.Debugf("Received %v listRouters from traefik.", len(routerInfos))
-	return
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		log.Errorf("Failed to convert traefik response of '%v'. Error: %v", path, err)
+		return err
+	}
+	return nil
 }
 
-func retrieveDomains(fn listRouters) []string {
-	routers := getEnabledRouterRules(fn)
-	return extractEffectiveDomains(routers)
+func retrieveDomains(ctx context.Context, fn listRouters, filter routerFilter) ([]domain.Domain, error) {
+	routers, err := getEnabledRouters(ctx, fn)
+	if err != nil {
+		return nil, err
+	}
+	return extractEffectiveDomains(routers, filter), nil
 }
 
-func getEnabledRouterRules(fn listRouters) (routers []string) {
-	routerList, err := fn()
+func getEnabledRouters(ctx context.Context, fn listRouters) (routers []router, err error) {
+	routerList, err := fn(ctx)
 
 	if err != nil {
 		log.Errorf("An error occurred while retrieving listRouters, won't extract any rules. Error: %s", err)
@@ -81,25 +225,102 @@ func getEnabledRouterRules(fn listRouters) (routers []string) {
 				router.Rule, router.Service, router.Status, strings.Join(router.Err, ","))
 			continue
 		}
-		routers = append(routers, router.Rule)
+		routers = append(routers, router)
 	}
 	return
 }
 
-func extractEffectiveDomains(routerRules []string) (domains []string) {
-	for _, rule := range routerRules {
-		parsed, err := rules.ParseDomains(rule)
+// extractEffectiveDomains parses the rules of the given routers either in their reported rule syntax or the detected one.
+// Domains of routers rejected by the filter as well as domains rejected on their own are dropped. Domains served by
+// multiple routers are merged and carry all of them together with their accepted entrypoints.
+func extractEffectiveDomains(routers []router, filter routerFilter) (domains []domain.Domain) {
+	for _, router := range routers {
+		parsed, err := ruleDomains(router.Rule, router.RuleSyntax)
 		if err != nil {
-			log.Errorf("Could not parse domain(s) from rule \"%s\". Error: %s", rule, err)
+			log.Errorf("Could not parse domain(s) from rule \"%s\" of router '%s'. Error: %s", router.Rule, router.Name, err)
 			continue
 		}
-		for _, domain := range parsed {
-			domains = appendIfNotExists(domains, domain)
+		routerRejection := filter.rejectRouter(router)
+		entryPoints := filter.acceptedEntryPoints(router)
+		source := router.descriptor(entryPoints)
+		metadata := router.metadata()
+		for _, name := range parsed {
+			rejection := routerRejection
+			if rejection == "" {
+				rejection = filter.rejectDomain(name)
+			}
+			if rejection != "" {
+				log.Debugf("Dropping domain '%s' of router '%s' due to %s.", name, router.Name, rejection)
+				continue
+			}
+			domains = mergeDomains(domains, domain.Domain{Name: name, EntryPoints: entryPoints, Routers: []domain.Router{source}, Metadata: metadata})
 		}
 	}
 	return
 }
 
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return s[:length] + "..."
+}
+
+// mergeDomains appends domains which are not part of the haystack yet. The entrypoints, routers and metadata of
+// already existing domains are extended by the ones of the new domain.
+func mergeDomains(haystack []domain.Domain, needles ...domain.Domain) []domain.Domain {
+	for _, needle := range needles {
+		merged := false
+		for i := range haystack {
+			if haystack[i].Name == needle.Name {
+				haystack[i] = mergeDomain(haystack[i], needle)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			haystack = append(haystack, mergeDomain(domain.Domain{Name: needle.Name}, needle))
+		}
+	}
+	return haystack
+}
+
+// mergeDomain adds everything of the source to the target. Metadata of the target takes precedence.
+func mergeDomain(target, source domain.Domain) domain.Domain {
+	target.EntryPoints = appendAllIfNotExists(target.EntryPoints, source.EntryPoints)
+	for _, r := range source.Routers {
+		if !containsRouter(target.Routers, r) {
+			target.Routers = append(target.Routers, r)
+		}
+	}
+	for key, value := range source.Metadata {
+		if target.Metadata == nil {
+			target.Metadata = map[string]string{}
+		}
+		if _, ok := target.Metadata[key]; !ok {
+			target.Metadata[key] = value
+		}
+	}
+	return target
+}
+
+// containsRouter checks whether the same router of the same protocol is already part of the haystack.
+func containsRouter(haystack []domain.Router, needle domain.Router) bool {
+	for _, r := range haystack {
+		if r.Name == needle.Name && r.Protocol == needle.Protocol {
+			return true
+		}
+	}
+	return false
+}
+
+func appendAllIfNotExists(haystack []string, needles []string) []string {
+	for _, needle := range needles {
+		haystack = appendIfNotExists(haystack, needle)
+	}
+	return haystack
+}
+
 func appendIfNotExists(haystack []string, needle string) []string {
 	for _, element := range haystack {
 		if element == needle {
@@ -110,5 +331,18 @@ func appendIfNotExists(haystack []string, needle string) []string {
 }
 
 type traefikConfig struct {
-	BaseURI string `split_words:"true"`
+	BaseURI     string `split_words:"true"`
+	HTTPEnabled bool   `split_words:"true" default:"true"`
+	TCPEnabled  bool   `split_words:"true" default:"false"`
+	// include and exclude filters either holding globs or regular expressions enclosed in slashes, see patternList
+	IncludeRouters     patternList `split_words:"true"`
+	ExcludeRouters     patternList `split_words:"true"`
+	IncludeProviders   patternList `split_words:"true"`
+	ExcludeProviders   patternList `split_words:"true"`
+	IncludeEntryPoints patternList `envconfig:"include_entrypoints"`
+	ExcludeEntryPoints patternList `envconfig:"exclude_entrypoints"`
+	IncludeServices    patternList `split_words:"true"`
+	ExcludeServices    patternList `split_words:"true"`
+	IncludeDomains     patternList `split_words:"true"`
+	ExcludeDomains     patternList `split_words:"true"`
 }
@@ -1,35 +1,40 @@
 package traefik
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/jenpet/traebeler/internal/domain"
 	"github.com/stretchr/testify/assert"
-	"github.com/traefik/traefik/v2/pkg/config/dynamic"
 	traefik "github.com/traefik/traefik/v2/pkg/config/runtime"
+	"gopkg.in/h2non/gock.v1"
+	"net/http"
 	"strings"
 	"testing"
 )
 
-func TestGetEnabledRouterRules_whenSomeRoutersNotEnabled_shouldOnlyReturnEnabledRouterRules(t *testing.T) {
+func TestGetEnabledRouters_whenSomeRoutersNotEnabled_shouldOnlyReturnEnabledRouters(t *testing.T) {
 	tp := createTestProvider()
-	rules := getEnabledRouterRules(tp.list)
-	assert.Len(t, rules, 2, "there should only be listRouters in the result which are enabled")
-	assert.Equal(t, "Host(`api.lospolloshermanos.com`,`ww.lospolloshermanos.com`,`lospolloshermanos.com`)", rules[0], "result should contain rules of listRouters")
+	routers, err := getEnabledRouters(context.Background(), tp.list)
+	assert.Nil(t, err)
+	assert.Len(t, routers, 2, "there should only be listRouters in the result which are enabled")
+	assert.Equal(t, "Host(`api.lospolloshermanos.com`,`ww.lospolloshermanos.com`,`lospolloshermanos.com`)", routers[0].Rule, "result should contain rules of listRouters")
 }
 
-func TestGetEnabledRouterRules_whenAnErrorOccurred_shouldNotReturnAnyRouterRules(t *testing.T) {
+func TestGetEnabledRouters_whenAnErrorOccurred_shouldNotReturnAnyRouters(t *testing.T) {
 	tp := createTestProvider()
 	tp.err = errors.New("error stuff")
-	rules := getEnabledRouterRules(tp.list)
-	assert.Empty(t, rules, "there should be no router rules returned when an error occurs")
+	routers, err := getEnabledRouters(context.Background(), tp.list)
+	assert.NotNil(t, err, "the error should be returned")
+	assert.Empty(t, routers, "there should be no routers returned when an error occurs")
 }
 
 func TestExtractEffectiveDomains_shouldReturnListWithoutDuplicates(t *testing.T) {
-	rules := []string{
-		createTestHostRule("lospolloshermanos.com", "api.lospolloshermanos.com", "ww.lospolloshermanos.com", "lospolloshermanos.com"),
-		createTestHostRule("lospolloshermanos.com"),
+	routers := []router{
+		createTestRouter(traefik.StatusEnabled, nil, []string{"lospolloshermanos.com", "api.lospolloshermanos.com", "ww.lospolloshermanos.com", "lospolloshermanos.com"}),
+		createTestRouter(traefik.StatusEnabled, nil, []string{"lospolloshermanos.com"}),
 	}
-	domains := extractEffectiveDomains(rules)
+	domains := domain.Names(extractEffectiveDomains(routers, routerFilter{}))
 	assert.Len(t, domains, 3, "there should not be any duplicates in the list")
 	assert.Contains(t, domains, "lospolloshermanos.com")
 	assert.Contains(t, domains, "api.lospolloshermanos.com")
@@ -50,30 +55,135 @@ func TestExtractEffectiveDomains_shouldAlsoWorkWithComplexRules(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equal(t, tt.expDomains, extractEffectiveDomains([]string{tt.rule}))
+			assert.Equal(t, tt.expDomains, domain.Names(extractEffectiveDomains([]router{{Rule: tt.rule}}, routerFilter{})))
 		})
 	}
 }
 
+func TestExtractEffectiveDomains_whenHostSNI_shouldSkipCatchAll(t *testing.T) {
+	routers := []router{
+		{Rule: "HostSNI(`db.lospolloshermanos.com`)"},
+		{Rule: "HostSNI(`*`)"},
+		{Rule: "HostSNI(`mqtt.lospolloshermanos.com`) || HostSNI(`db.lospolloshermanos.com`)"},
+	}
+	domains := extractEffectiveDomains(routers, routerFilter{})
+	assert.Equal(t, []string{"db.lospolloshermanos.com", "mqtt.lospolloshermanos.com"}, domain.Names(domains))
+}
+
+func TestExtractEffectiveDomains_shouldAttachMetadataOfFirstRouter(t *testing.T) {
+	routers := []router{
+		{Name: "wiki@file", Service: "wiki", Priority: 25, Rule: "Host(`wiki.foo.bar`)"},
+		{Name: "wiki-lan@file", Service: "wiki-lan", Priority: 50, Rule: "Host(`wiki.foo.bar`) || Host(`lan.foo.bar`)"},
+		{Name: "plain@file", Rule: "Host(`plain.foo.bar`)"},
+	}
+	domains := extractEffectiveDomains(routers, routerFilter{})
+	assert.Equal(t, []string{"wiki.foo.bar", "lan.foo.bar", "plain.foo.bar"}, domain.Names(domains))
+	assert.Equal(t, map[string]string{"service": "wiki", "priority": "25"}, domains[0].Metadata, "metadata of the first router should take precedence")
+	assert.Equal(t, map[string]string{"service": "wiki-lan", "priority": "50"}, domains[1].Metadata)
+	assert.Nil(t, domains[2].Metadata, "routers without service and priority should not attach metadata")
+}
+
+func TestMergeDomain_shouldOnlyAddMissingMetadata(t *testing.T) {
+	target := domain.Domain{Name: "foo.bar", Metadata: map[string]string{"service": "first"}}
+	source := domain.Domain{Name: "foo.bar", Metadata: map[string]string{"service": "second", "ttl": "300"}}
+	assert.Equal(t, map[string]string{"service": "first", "ttl": "300"}, mergeDomain(target, source).Metadata)
+	assert.Equal(t, map[string]string{"service": "second", "ttl": "300"}, mergeDomain(domain.Domain{Name: "foo.bar"}, source).Metadata)
+}
+
+func TestGetDomains_shouldOnlyQueryEnabledProtocols(t *testing.T) {
+	defer gock.Off()
+	var protocolTests = []struct {
+		name       string
+		http, tcp  bool
+		expDomains []string
+	}{
+		{"http only", true, false, []string{"foo.bar"}},
+		{"tcp only", false, true, []string{"db.foo.bar", "mqtt.foo.bar", "broker.foo.bar"}},
+		{"http and tcp", true, true, []string{"foo.bar", "db.foo.bar", "mqtt.foo.bar", "broker.foo.bar"}},
+	}
+	for _, tt := range protocolTests {
+		t.Run(tt.name, func(t *testing.T) {
+			gock.Clean()
+			gock.New("http://traefik.io").
+				Get("/api/http/routers").
+				Reply(http.StatusOK).
+				File("../test/data/traefik/http_routers_response.json")
+			gock.New("http://traefik.io").
+				Get("/api/tcp/routers").
+				Reply(http.StatusOK).
+				File("testdata/tcp_routers_response.json")
+			ta := traefikAPI{baseURI: "http://traefik.io", http: tt.http, tcp: tt.tcp}
+			domains, err := ta.GetDomains(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expDomains, domain.Names(domains))
+		})
+	}
+}
+
+func TestGetDomains_whenTraefikFails_shouldReturnError(t *testing.T) {
+	defer gock.Off()
+	var failureTests = []struct {
+		name  string
+		reply func(*gock.Response)
+	}{
+		{"unreachable", func(response *gock.Response) { response.Error = errors.New("connection refused") }},
+		{"server error", func(response *gock.Response) {
+			response.Status(http.StatusInternalServerError)
+			response.BodyString("<html>502 Bad Gateway</html>")
+		}},
+		{"unauthorized", func(response *gock.Response) {
+			response.Status(http.StatusUnauthorized)
+			response.BodyString("[]")
+		}},
+		{"malformed body", func(response *gock.Response) {
+			response.Status(http.StatusOK)
+			response.BodyString("<html></html>")
+		}},
+	}
+	for _, tt := range failureTests {
+		t.Run(tt.name, func(t *testing.T) {
+			gock.Clean()
+			gock.New("http://traefik.io").
+				Get("/api/http/routers").
+				ReplyFunc(tt.reply)
+			domains, err := traefikAPI{baseURI: "http://traefik.io", http: true}.GetDomains(context.Background())
+			assert.NotNil(t, err, "a failing traefik API should result in an error")
+			assert.Nil(t, domains)
+		})
+	}
+}
+
+func TestGetDomains_whenOneProtocolFails_shouldReturnError(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://traefik.io").
+		Get("/api/http/routers").
+		Reply(http.StatusOK).
+		File("../test/data/traefik/http_routers_response.json")
+	gock.New("http://traefik.io").
+		Get("/api/tcp/routers").
+		Reply(http.StatusNotFound)
+	domains, err := traefikAPI{baseURI: "http://traefik.io", http: true, tcp: true}.GetDomains(context.Background())
+	assert.NotNil(t, err, "a partial result should not be returned")
+	assert.Nil(t, domains)
+}
+
 func createTestProvider() testProvider {
 	return testProvider{
-		routerList: []traefik.RouterInfo{
-			createTestRouterInfo(traefik.StatusDisabled, []string{}, []string{"veridian-dynamics.com"}),
-			createTestRouterInfo(traefik.StatusWarning, []string{"error1"}, []string{"dundermifflinpaper.com"}),
-			createTestRouterInfo(traefik.StatusEnabled, []string{}, []string{"api.lospolloshermanos.com", "ww.lospolloshermanos.com", "lospolloshermanos.com"}),
-			createTestRouterInfo(traefik.StatusEnabled, []string{}, []string{"bettercallsaul.com"}),
+		routerList: []router{
+			createTestRouter(traefik.StatusDisabled, []string{}, []string{"veridian-dynamics.com"}),
+			createTestRouter(traefik.StatusWarning, []string{"error1"}, []string{"dundermifflinpaper.com"}),
+			createTestRouter(traefik.StatusEnabled, []string{}, []string{"api.lospolloshermanos.com", "ww.lospolloshermanos.com", "lospolloshermanos.com"}),
+			createTestRouter(traefik.StatusEnabled, []string{}, []string{"bettercallsaul.com"}),
 		},
 	}
 }
 
-func createTestRouterInfo(status string, err []string, hosts []string) traefik.RouterInfo {
-	return traefik.RouterInfo{
-		Router: &dynamic.Router{
-			Service: "default-service",
-			Rule:    createTestHostRule(hosts...),
-		},
-		Err:    err,
-		Status: status,
+func createTestRouter(status string, err []string, hosts []string) router {
+	return router{
+		Service: "default-service",
+		Rule:    createTestHostRule(hosts...),
+		Err:     err,
+		Status:  status,
 	}
 }
 
@@ -85,10 +195,10 @@ func createTestHostRule(hosts ...string) string {
 }
 
 type testProvider struct {
-	routerList []traefik.RouterInfo
+	routerList []router
 	err        error
 }
 
-func (tp testProvider) list() ([]traefik.RouterInfo, error) {
+func (tp testProvider) list(_ context.Context) ([]router, error) {
 	return tp.routerList, tp.err
 }
@@ -0,0 +1,205 @@
+package traefik
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// routerFilter decides which routers and domains are published. A router is filtered by its name, provider,
+// entrypoints and service whereas the domains extracted from its rule are filtered by their name.
+// The zero value accepts everything.
+type routerFilter struct {
+	names       patternFilter
+	providers   patternFilter
+	entryPoints patternFilter
+	services    patternFilter
+	domains     patternFilter
+}
+
+func newRouterFilter(cfg traefikConfig) (f routerFilter, err error) {
+	if f.names, err = newPatternFilter("router", cfg.IncludeRouters, cfg.ExcludeRouters); err != nil {
+		return
+	}
+	if f.providers, err = newPatternFilter("provider", trimProviderPrefixes(cfg.IncludeProviders), trimProviderPrefixes(cfg.ExcludeProviders)); err != nil {
+		return
+	}
+	if f.entryPoints, err = newPatternFilter("entrypoint", cfg.IncludeEntryPoints, cfg.ExcludeEntryPoints); err != nil {
+		return
+	}
+	if f.services, err = newPatternFilter("service", cfg.IncludeServices, cfg.ExcludeServices); err != nil {
+		return
+	}
+	f.domains, err = newPatternFilter("domain", cfg.IncludeDomains, cfg.ExcludeDomains)
+	return
+}
+
+// trimProviderPrefixes allows providers to be configured in their router name suffix notation, e.g. "@docker".
+func trimProviderPrefixes(raws []string) (trimmed []string) {
+	for _, raw := range raws {
+		trimmed = append(trimmed, strings.TrimPrefix(strings.TrimSpace(raw), "@"))
+	}
+	return
+}
+
+// rejectRouter returns the reason why a router is not published or an empty string if it is accepted.
+// Routers listening on multiple entrypoints are accepted as long as one of the entrypoints is accepted.
+func (f routerFilter) rejectRouter(r router) string {
+	if reason := f.names.reject(r.Name); reason != "" {
+		return reason
+	}
+	if reason := f.providers.reject(r.provider()); reason != "" {
+		return reason
+	}
+	if reason := f.entryPoints.rejectAll(r.EntryPoints); reason != "" {
+		return reason
+	}
+	return f.services.reject(r.Service)
+}
+
+// acceptedEntryPoints returns the entrypoints of a router which pass the entrypoint filter.
+func (f routerFilter) acceptedEntryPoints(r router) []string {
+	var accepted []string
+	for _, entryPoint := range r.EntryPoints {
+		if f.entryPoints.rejectValue(entryPoint) == "" {
+			accepted = append(accepted, entryPoint)
+		}
+	}
+	return accepted
+}
+
+// rejectDomain returns the reason why a domain is not published or an empty string if it is accepted.
+func (f routerFilter) rejectDomain(domain string) string {
+	return f.domains.reject(domain)
+}
+
+// patternFilter includes and excludes values based on patterns. In case there are include patterns a value
+// has to match at least one of them. A value matching any exclude pattern is rejected.
+type patternFilter struct {
+	name             string
+	include, exclude []pattern
+}
+
+func newPatternFilter(name string, include, exclude []string) (patternFilter, error) {
+	f := patternFilter{name: name}
+	var err error
+	if f.include, err = newPatterns(include); err != nil {
+		return f, fmt.Errorf("invalid include %s filter: %v", name, err)
+	}
+	if f.exclude, err = newPatterns(exclude); err != nil {
+		return f, fmt.Errorf("invalid exclude %s filter: %v", name, err)
+	}
+	return f, nil
+}
+
+func (f patternFilter) reject(value string) string {
+	return f.rejectAll([]string{value})
+}
+
+// rejectAll rejects a set of values in case none of them passes the filter.
+func (f patternFilter) rejectAll(values []string) string {
+	reason := ""
+	for _, value := range values {
+		if reason = f.rejectValue(value); reason == "" {
+			return ""
+		}
+	}
+	if len(values) == 0 && len(f.include) > 0 {
+		return fmt.Sprintf("include %s filter %v", f.name, f.include)
+	}
+	return reason
+}
+
+func (f patternFilter) rejectValue(value string) string {
+	if len(f.include) > 0 && matchAny(f.include, value) == nil {
+		return fmt.Sprintf("include %s filter %v", f.name, f.include)
+	}
+	if p := matchAny(f.exclude, value); p != nil {
+		return fmt.Sprintf("exclude %s filter '%s'", f.name, p)
+	}
+	return ""
+}
+
+func matchAny(patterns []pattern, value string) *pattern {
+	for i := range patterns {
+		if patterns[i].match(value) {
+			return &patterns[i]
+		}
+	}
+	return nil
+}
+
+// pattern matches a value either as glob (e.g. `*.internal.foo.bar`) or as regular expression in case it is
+// enclosed in slashes (e.g. `/^staging-.*$/`).
+type pattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+// patternList is a comma separated list of patterns. Other than envconfig's splitting of []string values a comma
+// within a regular expression (e.g. `/^(a|b){1,3}\.foo\.bar$/`) does not end the pattern, an entry starting with a
+// slash continues up to the next comma preceded by a slash.
+type patternList []string
+
+// Decode implements envconfig.Decoder.
+func (pl *patternList) Decode(value string) error {
+	var list patternList
+	for _, part := range strings.Split(value, ",") {
+		if last := len(list) - 1; last >= 0 && openRegexp(list[last]) {
+			list[last] += "," + part
+			continue
+		}
+		list = append(list, part)
+	}
+	*pl = list
+	return nil
+}
+
+// openRegexp tells whether a raw pattern starts a regular expression which is not yet closed by a slash.
+func openRegexp(raw string) bool {
+	raw = strings.TrimSpace(raw)
+	return strings.HasPrefix(raw, "/") && (len(raw) == 1 || !strings.HasSuffix(raw, "/"))
+}
+
+func newPatterns(raws []string) ([]pattern, error) {
+	var patterns []pattern
+	for _, raw := range raws {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		p, err := newPattern(raw)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func newPattern(raw string) (pattern, error) {
+	if len(raw) > 1 && strings.HasPrefix(raw, "/") && strings.HasSuffix(raw, "/") {
+		re, err := regexp.Compile(raw[1 : len(raw)-1])
+		if err != nil {
+			return pattern{}, fmt.Errorf("invalid regular expression '%s': %v", raw, err)
+		}
+		return pattern{raw: raw, re: re}, nil
+	}
+	if _, err := path.Match(raw, ""); err != nil {
+		return pattern{}, fmt.Errorf("invalid glob '%s': %v", raw, err)
+	}
+	return pattern{raw: raw}, nil
+}
+
+func (p pattern) match(value string) bool {
+	if p.re != nil {
+		return p.re.MatchString(value)
+	}
+	matched, _ := path.Match(p.raw, value)
+	return matched
+}
+
+func (p pattern) String() string {
+	return p.raw
+}
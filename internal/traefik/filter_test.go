@@ -0,0 +1,147 @@
+package traefik
+
+import (
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/jenpet/traebeler/internal/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRouterFilter_shouldOnlyAcceptMatchingRoutersAndDomains(t *testing.T) {
+	routers := []router{
+		{Name: "api@internal", Provider: "internal", EntryPoints: []string{"traefik"}, Service: "api@internal", Rule: "Host(`traefik.foo.bar`)"},
+		{Name: "shop@docker", EntryPoints: []string{"web-public"}, Service: "shop", Rule: "Host(`shop.foo.bar`)"},
+		{Name: "nas@file", EntryPoints: []string{"web-lan"}, Service: "nas", Rule: "Host(`nas.internal.foo.bar`)"},
+		{Name: "wiki@file", EntryPoints: []string{"web-lan", "web-public"}, Service: "wiki", Rule: "Host(`wiki.foo.bar`)"},
+		{Name: "staging-shop@docker", EntryPoints: []string{"web-public"}, Service: "shop-staging", Rule: "Host(`staging.foo.bar`)"},
+	}
+	var filterTests = []struct {
+		name       string
+		cfg        traefikConfig
+		expDomains []string
+	}{
+		{
+			"no filters",
+			traefikConfig{},
+			[]string{"traefik.foo.bar", "shop.foo.bar", "nas.internal.foo.bar", "wiki.foo.bar", "staging.foo.bar"},
+		},
+		{
+			"exclude internal provider in suffix notation",
+			traefikConfig{ExcludeProviders: []string{"@internal"}},
+			[]string{"shop.foo.bar", "nas.internal.foo.bar", "wiki.foo.bar", "staging.foo.bar"},
+		},
+		{
+			"include docker provider",
+			traefikConfig{IncludeProviders: []string{"docker"}},
+			[]string{"shop.foo.bar", "staging.foo.bar"},
+		},
+		{
+			"exclude router names by regular expression",
+			traefikConfig{ExcludeRouters: []string{"/^staging-/"}},
+			[]string{"traefik.foo.bar", "shop.foo.bar", "nas.internal.foo.bar", "wiki.foo.bar"},
+		},
+		{
+			"include router names by glob",
+			traefikConfig{IncludeRouters: []string{"*@file"}},
+			[]string{"nas.internal.foo.bar", "wiki.foo.bar"},
+		},
+		{
+			"exclude lan entrypoint keeps routers on other entrypoints",
+			traefikConfig{ExcludeEntryPoints: []string{"web-lan", "traefik"}},
+			[]string{"shop.foo.bar", "wiki.foo.bar", "staging.foo.bar"},
+		},
+		{
+			"include public entrypoint",
+			traefikConfig{IncludeEntryPoints: []string{"web-public"}},
+			[]string{"shop.foo.bar", "wiki.foo.bar", "staging.foo.bar"},
+		},
+		{
+			"exclude services",
+			traefikConfig{ExcludeServices: []string{"*-staging", "*@internal"}},
+			[]string{"shop.foo.bar", "nas.internal.foo.bar", "wiki.foo.bar"},
+		},
+		{
+			"exclude domains",
+			traefikConfig{ExcludeDomains: []string{"*.internal.foo.bar", "traefik.*"}},
+			[]string{"shop.foo.bar", "wiki.foo.bar", "staging.foo.bar"},
+		},
+		{
+			"include domains",
+			traefikConfig{IncludeDomains: []string{"/^(shop|wiki)\\./"}},
+			[]string{"shop.foo.bar", "wiki.foo.bar"},
+		},
+	}
+	for _, tt := range filterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newRouterFilter(tt.cfg)
+			assert.Nil(t, err, "filter should be valid")
+			assert.Equal(t, tt.expDomains, domain.Names(extractEffectiveDomains(routers, filter)))
+		})
+	}
+}
+
+func TestNewRouterFilter_whenPatternIsInvalid_shouldReturnError(t *testing.T) {
+	var invalidTests = []struct {
+		name string
+		cfg  traefikConfig
+	}{
+		{"invalid regular expression", traefikConfig{IncludeRouters: []string{"/(/"}}},
+		{"invalid glob", traefikConfig{ExcludeDomains: []string{"[foo.bar"}}},
+	}
+	for _, tt := range invalidTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newRouterFilter(tt.cfg)
+			assert.NotNil(t, err, "invalid patterns should result in an error")
+		})
+	}
+}
+
+func TestPatternListDecode_shouldKeepCommasWithinRegularExpressions(t *testing.T) {
+	var decodeTests = []struct {
+		raw      string
+		expected patternList
+	}{
+		{"*@internal,shop@docker", patternList{"*@internal", "shop@docker"}},
+		{"/^(a|b){1,3}\\.foo\\.bar$/", patternList{"/^(a|b){1,3}\\.foo\\.bar$/"}},
+		{"*.lan.foo.bar, /^x{2,}\\./ ,traefik.*", patternList{"*.lan.foo.bar", " /^x{2,}\\./ ", "traefik.*"}},
+		{"/,foo.bar", patternList{"/,foo.bar"}},
+	}
+	for _, tt := range decodeTests {
+		t.Run(tt.raw, func(t *testing.T) {
+			var pl patternList
+			assert.Nil(t, pl.Decode(tt.raw))
+			assert.Equal(t, tt.expected, pl)
+		})
+	}
+}
+
+func TestNewProvider_whenRegularExpressionContainsComma_shouldMatchIt(t *testing.T) {
+	defer test.ClearEnvs(test.SetEnvs(map[string]string{"TRAEFIK_INCLUDE_DOMAINS": "/^(a|b){1,3}\\.foo\\.bar$/,nas.*"}))
+	provider, err := NewProvider()
+	assert.Nil(t, err, "regular expression containing a comma should be valid")
+	routers := []router{{Rule: "Host(`ab.foo.bar`) || Host(`abab.foo.bar`) || Host(`nas.foo.bar`)"}}
+	assert.Equal(t, []string{"ab.foo.bar", "nas.foo.bar"}, domain.Names(extractEffectiveDomains(routers, provider.filter)))
+}
+
+func TestRouterProvider_shouldFallbackToNameSuffix(t *testing.T) {
+	assert.Equal(t, "docker", router{Name: "shop@docker"}.provider())
+	assert.Equal(t, "file", router{Name: "shop@docker", Provider: "file"}.provider())
+	assert.Equal(t, "", router{Name: "shop"}.provider())
+}
+
+func TestExtractEffectiveDomains_shouldCarryAcceptedEntryPointsOfAllRouters(t *testing.T) {
+	routers := []router{
+		{Name: "wiki-lan@file", EntryPoints: []string{"web-lan", "traefik"}, Rule: "Host(`wiki.foo.bar`)"},
+		{Name: "wiki@file", EntryPoints: []string{"web-public", "web-lan"}, Rule: "Host(`wiki.foo.bar`) || Host(`www.foo.bar`)"},
+		{Name: "default@file", Rule: "Host(`foo.bar`)"},
+	}
+	filter, err := newRouterFilter(traefikConfig{ExcludeEntryPoints: []string{"traefik"}})
+	assert.Nil(t, err)
+	wikiLan := domain.Router{Name: "wiki-lan@file", Provider: "file", EntryPoints: []string{"web-lan"}}
+	wiki := domain.Router{Name: "wiki@file", Provider: "file", EntryPoints: []string{"web-public", "web-lan"}}
+	assert.Equal(t, []domain.Domain{
+		{Name: "wiki.foo.bar", EntryPoints: []string{"web-lan", "web-public"}, Routers: []domain.Router{wikiLan, wiki}},
+		{Name: "www.foo.bar", EntryPoints: []string{"web-public", "web-lan"}, Routers: []domain.Router{wiki}},
+		{Name: "foo.bar", Routers: []domain.Router{{Name: "default@file", Provider: "file"}}},
+	}, extractEffectiveDomains(routers, filter))
+}
@@ -0,0 +1,354 @@
+package traefik
+
+import (
+	"fmt"
+	"github.com/jenpet/traebeler/internal/log"
+	"regexp"
+	resyntax "regexp/syntax"
+	"strconv"
+	"strings"
+)
+
+// Rule syntaxes as reported by traefik v3 in the "ruleSyntax" field of a router.
+const (
+	ruleSyntaxV2 = "v2"
+	ruleSyntaxV3 = "v3"
+)
+
+// v2HostRegexpVariable matches the `{name:regexp}` placeholders of traefik v2 HostRegexp matchers.
+var v2HostRegexpVariable = regexp.MustCompile(`\{[a-zA-Z_][a-zA-Z0-9_]*(:.*)?\}`)
+
+// ruleDomains extracts the hostnames of all Host, HostHeader, HostSNI and literal HostRegexp matchers of a rule.
+// The syntax decides how the matchers are interpreted. In case it is empty or unknown the syntax is detected from
+// the rule itself. Negated matchers and the catch-all HostSNI(`*`) do not represent a domain and are skipped.
+func ruleDomains(rule, syntax string) ([]string, error) {
+	matchers, err := parseRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	syntax = strings.ToLower(syntax)
+	if syntax != ruleSyntaxV2 && syntax != ruleSyntaxV3 {
+		syntax = detectRuleSyntax(matchers)
+		log.Debugf("Detected rule syntax %s for rule \"%s\".", syntax, rule)
+	}
+
+	var domains []string
+	for _, m := range matchers {
+		if m.negated {
+			continue
+		}
+		hosts, err := matcherDomains(m, syntax)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			host = strings.ToLower(host)
+			if host == "*" {
+				log.Debugf("Skipping catch-all %s of rule \"%s\".", m.name, rule)
+				continue
+			}
+			domains = appendIfNotExists(domains, host)
+		}
+	}
+	return domains, nil
+}
+
+// matcherDomains returns the hostnames a single matcher routes for interpreted in the given syntax.
+func matcherDomains(m matcher, syntax string) ([]string, error) {
+	switch strings.ToLower(m.name) {
+	case "host", "hostsni":
+		if syntax == ruleSyntaxV3 && len(m.args) != 1 {
+			return nil, fmt.Errorf("matcher %s expects exactly one argument in rule syntax v3 but got %d", m.name, len(m.args))
+		}
+		return m.args, nil
+	case "hostheader":
+		if syntax == ruleSyntaxV3 {
+			return nil, fmt.Errorf("matcher %s is not supported in rule syntax v3", m.name)
+		}
+		return m.args, nil
+	case "hostregexp", "hostsniregexp":
+		var hosts []string
+		for _, arg := range m.args {
+			host, ok := literalHost(arg, syntax)
+			if !ok {
+				log.Debugf("Skipping %s(`%s`) since it does not describe a single hostname.", m.name, arg)
+				continue
+			}
+			hosts = append(hosts, host)
+		}
+		return hosts, nil
+	}
+	return nil, nil
+}
+
+// literalHost returns the hostname of a HostRegexp argument in case it only matches a single hostname.
+// In v2 these are arguments without any `{name:regexp}` placeholder, in v3 regular expressions that are a plain literal
+// (optionally anchored), e.g. `^foo\.bar$`.
+func literalHost(arg, syntax string) (string, bool) {
+	if syntax == ruleSyntaxV2 {
+		return arg, !strings.ContainsAny(arg, "{}")
+	}
+	re, err := resyntax.Parse(arg, resyntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	re = re.Simplify()
+	if re.Op == resyntax.OpLiteral {
+		return string(re.Rune), true
+	}
+	if re.Op != resyntax.OpConcat {
+		return "", false
+	}
+	host := ""
+	for _, sub := range re.Sub {
+		switch sub.Op {
+		case resyntax.OpBeginLine, resyntax.OpBeginText, resyntax.OpEndLine, resyntax.OpEndText:
+		case resyntax.OpLiteral:
+			if host != "" {
+				return "", false
+			}
+			host = string(sub.Rune)
+		default:
+			return "", false
+		}
+	}
+	return host, host != ""
+}
+
+// detectRuleSyntax guesses the syntax of a rule which does not state it explicitly. Only matchers which differ between
+// both syntaxes are conclusive. Rules without any of them are treated as v2 since they are interpreted the same way.
+func detectRuleSyntax(matchers []matcher) string {
+	for _, m := range matchers {
+		switch strings.ToLower(m.name) {
+		case "headers", "headersregexp", "hostheader":
+			return ruleSyntaxV2
+		case "header", "headerregexp", "pathregexp", "queryregexp", "hostsniregexp":
+			return ruleSyntaxV3
+		case "host", "hostsni", "method", "path", "pathprefix", "clientip":
+			if len(m.args) > 1 {
+				return ruleSyntaxV2
+			}
+		case "hostregexp":
+			if len(m.args) > 1 {
+				return ruleSyntaxV2
+			}
+			for _, arg := range m.args {
+				if v2HostRegexpVariable.MatchString(arg) {
+					return ruleSyntaxV2
+				}
+				if strings.ContainsAny(arg, `^$\`) {
+					return ruleSyntaxV3
+				}
+			}
+		}
+	}
+	return ruleSyntaxV2
+}
+
+// matcher is a single matcher function of a rule, e.g. Host(`foo.bar`). A matcher is negated when an odd number of
+// negations applies to it, e.g. !Host(`foo.bar`) or !(Path(`/`) || Host(`foo.bar`)).
+type matcher struct {
+	name    string
+	args    []string
+	negated bool
+}
+
+// parseRule parses a traefik rule and returns all of its matchers. The rule grammar is shared by both syntaxes:
+// matchers with string arguments which are combined using &&, || and ! and grouped by parentheses.
+func parseRule(rule string) ([]matcher, error) {
+	tokens, err := tokenizeRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	p := ruleParser{tokens: tokens}
+	if err := p.parseOr(false); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", t.value, t.pos)
+	}
+	return p.matchers, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func tokenizeRule(rule string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(rule); {
+		c := rule[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case c == '!':
+			tokens = append(tokens, token{tokenNot, "!", i})
+			i++
+		case strings.HasPrefix(rule[i:], "&&"):
+			tokens = append(tokens, token{tokenAnd, "&&", i})
+			i += 2
+		case strings.HasPrefix(rule[i:], "||"):
+			tokens = append(tokens, token{tokenOr, "||", i})
+			i += 2
+		case c == '`':
+			end := strings.IndexByte(rule[i+1:], '`')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{tokenString, rule[i+1 : i+1+end], i})
+			i += end + 2
+		case c == '"':
+			end := i + 1
+			for ; end < len(rule) && rule[end] != '"'; end++ {
+				if rule[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(rule) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			value, err := strconv.Unquote(rule[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %v", i, err)
+			}
+			tokens = append(tokens, token{tokenString, value, i})
+			i = end + 1
+		case isIdentChar(c):
+			start := i
+			for i < len(rule) && isIdentChar(rule[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, rule[start:i], start})
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at position %d", c, i)
+		}
+	}
+	return append(tokens, token{tokenEOF, "end of rule", len(rule)}), nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// ruleParser is a recursive descent parser collecting the matchers of a tokenized rule.
+type ruleParser struct {
+	tokens   []token
+	pos      int
+	matchers []matcher
+}
+
+func (p *ruleParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *ruleParser) expect(kind tokenKind, desc string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, fmt.Errorf("expected %s but got '%s' at position %d", desc, t.value, t.pos)
+	}
+	return t, nil
+}
+
+func (p *ruleParser) parseOr(negated bool) error {
+	if err := p.parseAnd(negated); err != nil {
+		return err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		if err := p.parseAnd(negated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ruleParser) parseAnd(negated bool) error {
+	if err := p.parseUnary(negated); err != nil {
+		return err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		if err := p.parseUnary(negated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ruleParser) parseUnary(negated bool) error {
+	t := p.next()
+	switch t.kind {
+	case tokenNot:
+		return p.parseUnary(!negated)
+	case tokenLParen:
+		if err := p.parseOr(negated); err != nil {
+			return err
+		}
+		_, err := p.expect(tokenRParen, "')'")
+		return err
+	case tokenIdent:
+		return p.parseMatcher(t.value, negated)
+	}
+	return fmt.Errorf("expected matcher but got '%s' at position %d", t.value, t.pos)
+}
+
+func (p *ruleParser) parseMatcher(name string, negated bool) error {
+	if _, err := p.expect(tokenLParen, "'(' after matcher "+name); err != nil {
+		return err
+	}
+	m := matcher{name: name, negated: negated}
+	if p.peek().kind == tokenRParen {
+		p.next()
+		p.matchers = append(p.matchers, m)
+		return nil
+	}
+	for {
+		arg, err := p.expect(tokenString, "string argument")
+		if err != nil {
+			return err
+		}
+		m.args = append(m.args, arg.value)
+		t := p.next()
+		if t.kind == tokenRParen {
+			break
+		}
+		if t.kind != tokenComma {
+			return fmt.Errorf("expected ',' or ')' but got '%s' at position %d", t.value, t.pos)
+		}
+	}
+	p.matchers = append(p.matchers, m)
+	return nil
+}
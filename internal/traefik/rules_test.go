@@ -0,0 +1,134 @@
+package traefik
+
+import (
+	"context"
+	"github.com/jenpet/traebeler/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	"net/http"
+	"testing"
+)
+
+func TestRuleDomains_shouldExtractHostnamesOfBothSyntaxes(t *testing.T) {
+	var ruleTests = []struct {
+		name          string
+		rule          string
+		syntax        string
+		expDomains    []string
+		errorExpected bool
+	}{
+		{"v2 single host", "Host(`foo.bar`)", ruleSyntaxV2, []string{"foo.bar"}, false},
+		{"v2 multiple hosts", "Host(`foo.bar`, `www.foo.bar`)", ruleSyntaxV2, []string{"foo.bar", "www.foo.bar"}, false},
+		{"v2 host header", "HostHeader(`foo.bar`)", ruleSyntaxV2, []string{"foo.bar"}, false},
+		{"v2 host regexp with variable", "HostRegexp(`{sub:[a-z]+}.foo.bar`)", ruleSyntaxV2, nil, false},
+		{"v2 literal host regexp", "HostRegexp(`foo.bar`)", ruleSyntaxV2, []string{"foo.bar"}, false},
+		{"v2 double quoted strings", `Host("foo.bar") && Path("/")`, ruleSyntaxV2, []string{"foo.bar"}, false},
+		{"v3 single host", "Host(`foo.bar`)", ruleSyntaxV3, []string{"foo.bar"}, false},
+		{"v3 hosts combined by or", "Host(`foo.bar`) || Host(`www.foo.bar`)", ruleSyntaxV3, []string{"foo.bar", "www.foo.bar"}, false},
+		{"v3 multiple host arguments", "Host(`foo.bar`, `www.foo.bar`)", ruleSyntaxV3, nil, true},
+		{"v3 host header", "HostHeader(`foo.bar`)", ruleSyntaxV3, nil, true},
+		{"v3 literal host regexp", "HostRegexp(`^api\\.foo\\.bar$`)", ruleSyntaxV3, []string{"api.foo.bar"}, false},
+		{"v3 case insensitive literal host regexp", "HostRegexp(`(?i)^API\\.foo\\.bar$`)", ruleSyntaxV3, []string{"api.foo.bar"}, false},
+		{"v3 host regexp pattern", "HostRegexp(`^[a-z]+\\.foo\\.bar$`)", ruleSyntaxV3, nil, false},
+		{"v3 literal host sni regexp", "HostSNIRegexp(`^db\\.foo\\.bar$`)", ruleSyntaxV3, []string{"db.foo.bar"}, false},
+		{"uppercase hosts are lowered", "Host(`FOO.bar`)", ruleSyntaxV3, []string{"foo.bar"}, false},
+		{"negated host is skipped", "Host(`foo.bar`) && !Host(`www.foo.bar`)", ruleSyntaxV3, []string{"foo.bar"}, false},
+		{"double negated host", "!(!Host(`foo.bar`) || Path(`/`))", ruleSyntaxV3, []string{"foo.bar"}, false},
+		{"nested groups", "(Host(`foo.bar`) || (Host(`a.foo.bar`) && PathPrefix(`/a`))) && Method(`GET`)", "", []string{"foo.bar", "a.foo.bar"}, false},
+		{"catch all host sni", "HostSNI(`*`)", ruleSyntaxV3, nil, false},
+		{"unknown syntax is detected", "Host(`foo.bar`, `www.foo.bar`)", "v9", []string{"foo.bar", "www.foo.bar"}, false},
+		{"unterminated string", "Host(`foo.bar)", "", nil, true},
+		{"missing closing parenthesis", "(Host(`foo.bar`)", "", nil, true},
+		{"dangling operator", "Host(`foo.bar`) &&", "", nil, true},
+		{"invalid character", "Host(`foo.bar`) & Path(`/`)", "", nil, true},
+	}
+	for _, tt := range ruleTests {
+		t.Run(tt.name, func(t *testing.T) {
+			domains, err := ruleDomains(tt.rule, tt.syntax)
+			assert.Equal(t, tt.errorExpected, err != nil, "error expected: '%t' but got '%v'", tt.errorExpected, err)
+			assert.Equal(t, tt.expDomains, domains)
+		})
+	}
+}
+
+func TestDetectRuleSyntax(t *testing.T) {
+	var syntaxTests = []struct {
+		name      string
+		rule      string
+		expSyntax string
+	}{
+		{"ambiguous rule defaults to v2", "Host(`foo.bar`) && PathPrefix(`/`)", ruleSyntaxV2},
+		{"multiple host arguments", "Host(`foo.bar`,`www.foo.bar`)", ruleSyntaxV2},
+		{"v2 headers matcher", "Host(`foo.bar`) && Headers(`X-Foo`, `bar`)", ruleSyntaxV2},
+		{"v2 host regexp variable", "HostRegexp(`{sub:[a-z]+}.foo.bar`)", ruleSyntaxV2},
+		{"v3 header matcher", "Host(`foo.bar`) && Header(`X-Foo`, `bar`)", ruleSyntaxV3},
+		{"v3 path regexp matcher", "Host(`foo.bar`) && PathRegexp(`^/api`)", ruleSyntaxV3},
+		{"v3 anchored host regexp", "HostRegexp(`^foo\\.bar$`)", ruleSyntaxV3},
+		{"v3 regexp quantifier", "HostRegexp(`^[a-z]{2,3}\\.foo\\.bar$`)", ruleSyntaxV3},
+	}
+	for _, tt := range syntaxTests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, err := parseRule(tt.rule)
+			assert.Nil(t, err, "rule should be parsable")
+			assert.Equal(t, tt.expSyntax, detectRuleSyntax(matchers))
+		})
+	}
+}
+
+func TestGetDomains_whenTraefikV3_shouldExtractDomainsOfAllProtocols(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://traefik.io").
+		Get("/api/http/routers").
+		Reply(http.StatusOK).
+		File("testdata/v3_http_routers_response.json")
+	gock.New("http://traefik.io").
+		Get("/api/tcp/routers").
+		Reply(http.StatusOK).
+		File("testdata/v3_tcp_routers_response.json")
+
+	domains, err := traefikAPI{baseURI: "http://traefik.io", http: true, tcp: true}.GetDomains(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"whoami.foo.bar",
+		"grafana.foo.bar",
+		"metrics.foo.bar",
+		"wiki.foo.bar",
+		"legacy.foo.bar",
+		"old.foo.bar",
+		"db.foo.bar",
+		"mqtt.foo.bar",
+	}, domain.Names(domains))
+}
+
+func TestGetDomains_shouldDescribeSourceRouters(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://traefik.io").
+		Get("/api/http/routers").
+		Reply(http.StatusOK).
+		File("testdata/v3_http_routers_response.json")
+	gock.New("http://traefik.io").
+		Get("/api/tcp/routers").
+		Reply(http.StatusOK).
+		File("testdata/v3_tcp_routers_response.json")
+
+	domains, err := traefikAPI{baseURI: "http://traefik.io", http: true, tcp: true}.GetDomains(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, domain.Domain{
+		Name:        "whoami.foo.bar",
+		EntryPoints: []string{"websecure"},
+		Routers: []domain.Router{{
+			Name:        "whoami@docker",
+			Provider:    "docker",
+			Protocol:    "http",
+			Service:     "whoami",
+			EntryPoints: []string{"websecure"},
+			TLS:         &domain.TLS{CertResolver: "letsencrypt"},
+		}},
+		Metadata: map[string]string{"service": "whoami", "priority": "25"},
+	}, domains[0])
+	db := domains[6]
+	assert.Equal(t, "db.foo.bar", db.Name)
+	assert.Equal(t, []string{"postgres@docker"}, db.RouterNames())
+	assert.Equal(t, "tcp", db.Routers[0].Protocol)
+	assert.Equal(t, &domain.TLS{Passthrough: true}, db.Routers[0].TLS)
+}
@@ -2,10 +2,19 @@ package internal
 
 import (
 	"context"
+	"fmt"
+	"github.com/jenpet/traebeler/internal/domain"
 	"github.com/jenpet/traebeler/internal/log"
+	"github.com/jenpet/traebeler/internal/metrics"
 	"github.com/jenpet/traebeler/internal/processing"
+	"github.com/jenpet/traebeler/internal/publicip"
 	"github.com/jenpet/traebeler/internal/traefik"
 	"github.com/kelseyhightower/envconfig"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
 )
 
 // Do will be called from main as an entrypoint.
@@ -13,29 +22,76 @@ func Do(ctx context.Context) {
 	perform(ctx)
 }
 
-// perform triggers the actual work on the provider and the processor.
+// perform triggers the actual work on the provider and the processors.
 func perform(ctx context.Context) {
 	cfg := loadConfig()
-	processor := getProcessor(cfg)
+	h := newHealth(time.Second * time.Duration(cfg.LookupInterval+cfg.LivenessTimeout))
+	serve(ctx, cfg.HTTPAddress, newMux(h))
+	processors := getProcessors(cfg)
+	h.processorsInitialized()
+	timeout := time.Second * time.Duration(cfg.CycleTimeout)
 	provider := traefik.Provider()
+	detector, err := publicip.NewDetector()
+	if err != nil {
+		log.Panicf("failed loading public IP detection configuration. Error: %v", err)
+	}
+	store, err := newBaselineStore()
+	if err != nil {
+		log.Panicf("failed loading state configuration. Error: %v", err)
+	}
 	timer := configuredClock(cfg)
-	workDomains(ctx, processor, provider, timer)
+	guard := newDeletionGuard(cfg)
+	listenApproval(ctx, guard)
+	// runs in progress are only aborted once the grace period elapsed after ctx got cancelled
+	work, abort := context.WithCancel(context.Background())
+	defer abort()
+	w := &worker{
+		provider:   provider,
+		timeout:    timeout,
+		addresses:  detector,
+		hysteresis: newHysteresis(cfg),
+		guard:      guard,
+		health:     h,
+		runners:    startRunners(ctx, work, processors, h, timeout),
+	}
+	w.restoreBaseline(store, cfg.DryRun)
+	workDomains(ctx, w, timer)
+	grace := time.Second * time.Duration(cfg.ShutdownGracePeriod)
+	log.Infof("Waiting up to %v for processors to finish.", grace)
+	drain(w.runners, grace, abort)
+}
+
+// listenApproval approves the next removal refused by the deletion guard every time SIGUSR1 is received.
+func listenApproval(ctx context.Context, guard *deletionGuard) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-c:
+				guard.approve()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 }
 
-// workDomains initially queries traefik for a first set of domains. The retrieved domains are passed to the
-// given processor _not_ validating for any errors. Subsequently it will query traefik every time the clock's ticker fires.
+// workDomains initially queries traefik for a first set of domains. The retrieved domains are passed to all the
+// given processors _not_ validating for any errors. Subsequently it will query traefik every time the clock's ticker fires.
 // The domains will be continuously fetched and forwarded until the context gets cancelled.
-func workDomains(ctx context.Context, processor processor, provider provider, c clock) {
+func workDomains(ctx context.Context, w *worker, c clock) {
 	log.Info("Started listening for domains...")
-	processDomains(provider, processor)
-	processDomainsOnTrigger(ctx, processor, provider, c)
+	_ = w.processDomains(ctx)
+	processDomainsOnTrigger(ctx, w, c)
 }
 
-func processDomainsOnTrigger(ctx context.Context, processor processor, provider provider, c clock) {
+func processDomainsOnTrigger(ctx context.Context, w *worker, c clock) {
 	for {
 		select {
 		case <-c.Ticker():
-			processDomains(provider, processor)
+			_ = w.processDomains(ctx)
 		case <-ctx.Done():
 			log.Info("Stopped listening for domains.")
 			return
@@ -43,33 +99,186 @@ func processDomainsOnTrigger(ctx context.Context, processor processor, provider
 	}
 }
 
-// processDomains retrieves a list of domains from the given provider and forwards it to the processor.
-func processDomains(provider provider, processor processor) {
+// worker retrieves domains from its provider as well as the public addresses and forwards them to the runners of all
+// processors.
+type worker struct {
+	provider provider
+	// timeout limits a single provider query, 0 for no limit
+	timeout time.Duration
+	// addresses is optional, without it the public addresses are never considered changed
+	addresses addressSource
+	// hysteresis is optional, without it domains are added and removed as soon as they are reported or missing
+	hysteresis *hysteresis
+	// guard is optional, without it any number of domains may be removed at once
+	guard *deletionGuard
+	// store is optional and persists the domains handed to the processors as baseline of the guard after a restart
+	store stateStore
+	// health is optional and records the outcome of every cycle
+	health  *health
+	runners []*runner
+	// previous holds the state of the last successful cycle
+	previous state
+	// providerFailures counts the consecutive failed provider queries, lastProviderErr holds the most recent error
+	providerFailures int
+	lastProviderErr  error
+}
+
+// processDomains retrieves a list of domains from the provider and forwards it together with the public addresses to
+// the runner of every processor.
+// In case the provider fails or the hysteresis did not settle yet the cycle is skipped, since processors would treat
+// the missing domains as removed ones.
+func (w *worker) processDomains(ctx context.Context) error {
+	start := time.Now()
+	current, settled, err := w.poll(ctx)
+	metrics.ObserveCycle(start, err)
+	if w.health != nil {
+		w.health.cycleDone(err)
+	}
+	if err != nil || !settled {
+		return err
+	}
+	for _, r := range w.runners {
+		r.submit(current)
+	}
+	return nil
+}
+
+// poll retrieves the domains from the provider, applies the hysteresis and deletion guard to them and detects the
+// public addresses. The returned state becomes the previous state of the next poll. A provider query exceeding the timeout
+// is aborted. The returned bool is false while the hysteresis did not settle yet, the state must not be processed then.
+func (w *worker) poll(ctx context.Context) (state, bool, error) {
 	log.Info("Querying for domains...")
-	domains := provider.GetDomains()
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+	domains, err := w.provider.GetDomains(ctx)
+	if err != nil {
+		w.providerFailures++
+		w.lastProviderErr = err
+		log.Errorf("Failed querying for domains (%d consecutive failures), skipping this cycle. Error: %v", w.providerFailures, err)
+		return state{}, false, err
+	}
+	if w.providerFailures > 0 {
+		log.Infof("Querying for domains succeeded again after %d failures.", w.providerFailures)
+	}
+	w.providerFailures = 0
+	w.lastProviderErr = nil
 	log.Infof("Done querying for domains. Received %v unique domains.", len(domains))
-	processor.Process(domains)
+	metrics.SetDomains(domains)
+	for _, d := range domains {
+		log.Debugf("Received domain %v on entrypoints %v.", d, d.EntryPoints)
+	}
+	if w.hysteresis != nil {
+		domains = w.hysteresis.apply(domains)
+		if !w.hysteresis.settled() {
+			log.Infof("Waiting for domains to be reported by %d of %d polls, skipping this cycle.", w.hysteresis.polls, w.hysteresis.addAfter)
+			return state{}, false, nil
+		}
+	}
+	if w.guard != nil {
+		domains = w.guard.apply(w.previous.domains, domains)
+	}
+	current := state{domains: domains}
+	if w.addresses != nil {
+		current.ipv4, current.ipv6 = w.addresses.Addresses(ctx)
+	}
+	changes := diff(w.previous, current)
+	log.Infof("Domains since the last cycle: %d added, %d removed, %d unchanged. Public IP changed: %t.",
+		len(changes.Added), len(changes.Removed), len(changes.Unchanged), changes.IPChanged)
+	w.saveBaseline(current.domains)
+	w.previous = current
+	return current, true, nil
+}
+
+// state is the outcome of a single worker cycle.
+type state struct {
+	domains    []domain.Domain
+	ipv4, ipv6 string
+}
+
+// diff returns the changes from the previous to the current state. Domains are identified by their name, a domain
+// whose descriptor differs from the previous one is considered to be added again.
+func diff(previous, current state) domain.ChangeSet {
+	changes := domain.ChangeSet{
+		Domains:   current.domains,
+		IPv4:      current.ipv4,
+		IPv6:      current.ipv6,
+		IPChanged: previous.ipv4 != current.ipv4 || previous.ipv6 != current.ipv6,
+	}
+	known := map[string]domain.Domain{}
+	for _, d := range previous.domains {
+		known[d.Name] = d
+	}
+	for _, d := range current.domains {
+		if p, ok := known[d.Name]; ok && reflect.DeepEqual(p, d) {
+			changes.Unchanged = append(changes.Unchanged, d)
+		} else {
+			changes.Added = append(changes.Added, d)
+		}
+		delete(known, d.Name)
+	}
+	for _, d := range previous.domains {
+		if _, ok := known[d.Name]; ok {
+			changes.Removed = append(changes.Removed, d)
+		}
+	}
+	return changes
 }
 
 func loadConfig() config {
+	cfg, err := readConfig()
+	if err != nil {
+		log.Panic(err)
+	}
+	return cfg
+}
+
+// readConfig reads and validates the TRAEBELER_* environment variables.
+func readConfig() (config, error) {
 	var cfg config
 	err := envconfig.Process("traebeler", &cfg)
 	if err != nil {
-		log.Panicf("failed processing worker environment variables. Error: %s", err)
+		return cfg, fmt.Errorf("failed processing worker environment variables. Error: %s", err)
 	}
-	if !cfg.valid() {
-		log.Panic("invalid worker config, either lookup interval is lte zero or the processor is undefined")
+	cfg.normalize()
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid worker config: %v", err)
 	}
-	return cfg
+	return cfg, nil
 }
 
-func getProcessor(cfg config) processor {
-	processor := processing.Repository().GetProcessor(cfg.Processor)
-	if processor == nil {
-		log.Panicf("failed looking up processor with id '%v'", cfg.Processor)
+// getProcessors looks up and initializes every configured processor. A single unknown or failing processor
+// is considered a misconfiguration and stops traebeler right away, as well as a processor which does not support
+// dry runs in case they are enabled.
+func getProcessors(cfg config) []processor {
+	processors, err := initProcessors(cfg)
+	if err != nil {
+		log.Panic(err)
 	}
-	if err := processor.Init(); err != nil {
-		log.Panicf("failed to initialize processor with ID '%v' due to error: %v", cfg.Processor, err)
+	return processors
+}
+
+func initProcessors(cfg config) ([]processor, error) {
+	var processors []processor
+	for _, id := range cfg.Processors {
+		processor := processing.Repository().GetProcessor(id)
+		if processor == nil {
+			return nil, fmt.Errorf("failed looking up processor with id '%v'", id)
+		}
+		if cfg.DryRun {
+			dp, ok := processor.(dryRunProcessor)
+			if !ok {
+				return nil, fmt.Errorf("processor with ID '%v' does not support dry runs", id)
+			}
+			dp.EnableDryRun()
+			log.Infof("Enabled dry run of processor with ID '%v'. No changes will be applied.", id)
+		}
+		if err := processor.Init(); err != nil {
+			return nil, fmt.Errorf("failed to initialize processor with ID '%v' due to error: %v", id, err)
+		}
+		processors = append(processors, processor)
 	}
-	return processor
+	return processors, nil
 }
@@ -22,6 +22,7 @@ var envVars = map[string]string{
 	"TRAEBELER_PROCESSOR_FROXLOR_URI": "http://froxlor.com",
 	"TRAEBELER_PROCESSOR_FROXLOR_KEY": "FROXLOR-KEY",
 	"TRAEBELER_PROCESSOR_FROXLOR_SECRET": "FROXLOR-SECRET",
+	"TRAEBELER_HTTP_ADDRESS": "127.0.0.1:0",
 }
 
 func TestMain(m *testing.M) {
@@ -42,7 +43,7 @@ func TestIntegrationFroxlorProcessor_shouldQueryTraefikAndUpdateFroxlor(t *testi
 		Get("/").
 		MatchParam("format", "text").
 		Reply(http.StatusOK).
-		BodyString("127.0.0.1")
+		BodyString("93.184.216.34")
 
 	gockFroxlor("DomainZones.listing", "test/data/froxlor/domainzone_listing_successful.json")
 	gockFroxlor("DomainZones.delete", "test/data/froxlor/domainzone_delete_success.json")
@@ -75,12 +76,16 @@ func TestIntegrationFroxlorProcessor_shouldQueryTraefikAndUpdateFroxlor(t *testi
 			case <-time.After(time.Second*30):
 				cancel()
 				assert.Fail(t, "integration test timed out")
+				return
 		}
 	}
 }
 
 func filterFroxlorCommand(command string) gock.FilterRequestFunc {
 	return func(request *http.Request) bool {
+		if request.GetBody == nil {
+			return false
+		}
 		// body is read multiple times by the different matchers. GetBody does not clear the body byte array.
 		reqBody, _ := request.GetBody()
 		b, _ := ioutil.ReadAll(reqBody)
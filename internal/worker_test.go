@@ -2,8 +2,11 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"github.com/jenpet/traebeler/internal/domain"
 	"github.com/jenpet/traebeler/internal/test"
 	"github.com/stretchr/testify/assert"
+	"sync"
 	"testing"
 	"time"
 )
@@ -17,7 +20,7 @@ func TestProcessDomainsOnTrigger_whenClockFires_shouldQueryDomainsAndTriggerProc
 	aProcessor := assertingProcessor{ t: t, expectedLen: 3, called: called}
 
 	go func() {
-		processDomainsOnTrigger(ctx, &aProcessor, sProvider, &tc)
+		processDomainsOnTrigger(ctx, &worker{provider: sProvider, runners: startRunners(ctx, ctx, []processor{&aProcessor}, nil, 0)}, &tc)
 	}()
 
 	tc.Trigger()
@@ -33,6 +36,88 @@ func TestProcessDomainsOnTrigger_whenClockFires_shouldQueryDomainsAndTriggerProc
 	}
 }
 
+func TestProcessDomains_shouldHandDomainsToEveryProcessor(t *testing.T) {
+	sProvider := staticProvider{"lospolloshermanos.com", "api.lospolloshermanos.com"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := assertingProcessor{t: t, expectedLen: 2, called: make(chan bool, 1)}
+	second := assertingProcessor{t: t, expectedLen: 2, called: make(chan bool, 1)}
+	w := worker{provider: sProvider, runners: startRunners(ctx, ctx, []processor{&first, &second}, nil, 0)}
+	assert.Nil(t, w.processDomains(ctx), "processing static domains should not fail")
+
+	for _, called := range []chan bool{first.called, second.called} {
+		select {
+		case <-called:
+		case <-time.After(time.Second * 1):
+			assert.Fail(t, "every processor should receive the domains")
+		}
+	}
+}
+
+func TestProcessDomains_whenProviderFails_shouldSkipCycleAndRecordFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	aProcessor := assertingProcessor{t: t, expectedLen: 1, called: make(chan bool, 1)}
+	fp := &failingProvider{err: errors.New("traefik unreachable")}
+	w := worker{provider: fp, runners: startRunners(ctx, ctx, []processor{&aProcessor}, nil, 0)}
+
+	assert.NotNil(t, w.processDomains(ctx), "a failing provider should fail the cycle")
+	assert.NotNil(t, w.processDomains(ctx), "a failing provider should fail the cycle")
+	assert.Equal(t, 2, w.providerFailures, "consecutive failures should be counted")
+	assert.EqualError(t, w.lastProviderErr, "traefik unreachable")
+	select {
+	case <-aProcessor.called:
+		assert.Fail(t, "processor should not be called when the provider fails")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	fp.err = nil
+	assert.Nil(t, w.processDomains(ctx), "a recovered provider should succeed")
+	assert.Equal(t, 0, w.providerFailures, "failures should be reset after a successful query")
+	assert.Nil(t, w.lastProviderErr)
+	select {
+	case <-aProcessor.called:
+	case <-time.After(time.Second * 1):
+		assert.Fail(t, "processor should be called once the provider recovered")
+	}
+}
+
+func TestProcessDomains_whenHysteresisNotSettled_shouldSkipCycle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	aProcessor := assertingProcessor{t: t, expectedLen: 1, called: make(chan bool, 1)}
+	w := worker{
+		provider:   staticProvider{"lospolloshermanos.com"},
+		hysteresis: newHysteresis(config{AddAfterPolls: 3, RemoveAfterPolls: 1}),
+		runners:    startRunners(ctx, ctx, []processor{&aProcessor}, nil, 0),
+	}
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, w.processDomains(ctx), "warm-up cycles should not fail")
+	}
+	select {
+	case <-aProcessor.called:
+		assert.Fail(t, "processor should not receive the incomplete domains during the warm-up")
+	case <-time.After(time.Millisecond * 100):
+	}
+	assert.Empty(t, w.previous.domains, "warm-up cycles should not become the previous state")
+
+	assert.Nil(t, w.processDomains(ctx))
+	select {
+	case <-aProcessor.called:
+	case <-time.After(time.Second * 1):
+		assert.Fail(t, "processor should be called once the hysteresis settled")
+	}
+}
+
+func TestLoadConfig_shouldSplitProcessors(t *testing.T) {
+	defer test.ClearEnvs(test.SetEnvs(map[string]string{"TRAEBELER_PROCESSOR": "froxlor, other,,froxlor"}))
+	cfg := loadConfig()
+	assert.Equal(t, []string{"froxlor", "other"}, cfg.Processors, "processors should be trimmed and unique")
+}
+
 func TestLoadConfig_whenEnvVarsAreInvalid_shouldPanic(t *testing.T) {
 	configTests := []struct {
 		name string
@@ -40,6 +125,12 @@ func TestLoadConfig_whenEnvVarsAreInvalid_shouldPanic(t *testing.T) {
 	}{
 		{"invalid interval value", map[string]string{"TRAEBELER_LOOKUP_INTERVAL":"1-.2"}},
 		{"interval leq zero", map[string]string{"TRAEBELER_LOOKUP_INTERVAL": "0"}},
+		{"no processor", map[string]string{"TRAEBELER_PROCESSOR": " , "}},
+		{"add after zero polls", map[string]string{"TRAEBELER_ADD_AFTER_POLLS": "0"}},
+		{"negative remove after polls", map[string]string{"TRAEBELER_REMOVE_AFTER_POLLS": "-1"}},
+		{"negative remove after seconds", map[string]string{"TRAEBELER_REMOVE_AFTER_SECONDS": "-1"}},
+		{"deletion guard percent above 100", map[string]string{"TRAEBELER_DELETION_GUARD_PERCENT": "101"}},
+		{"negative deletion guard count", map[string]string{"TRAEBELER_DELETION_GUARD_COUNT": "-1"}},
 	}
 
 	for _, tt := range configTests {
@@ -52,28 +143,109 @@ func TestLoadConfig_whenEnvVarsAreInvalid_shouldPanic(t *testing.T) {
 	}
 }
 
+func TestReadConfig_whenOptionIsOutOfRange_shouldNameIt(t *testing.T) {
+	configTests := []struct {
+		name     string
+		vars     map[string]string
+		expected string
+	}{
+		{"no processor", map[string]string{"TRAEBELER_PROCESSOR": " , "}, "TRAEBELER_PROCESSOR has to define at least one processor"},
+		{"interval leq zero", map[string]string{"TRAEBELER_LOOKUP_INTERVAL": "0"}, "TRAEBELER_LOOKUP_INTERVAL has to be at least 1 but is 0"},
+		{"add after zero polls", map[string]string{"TRAEBELER_ADD_AFTER_POLLS": "0"}, "TRAEBELER_ADD_AFTER_POLLS has to be at least 1 but is 0"},
+		{"negative remove after seconds", map[string]string{"TRAEBELER_REMOVE_AFTER_SECONDS": "-1"}, "TRAEBELER_REMOVE_AFTER_SECONDS has to be at least 0 but is -1"},
+		{"deletion guard percent above 100", map[string]string{"TRAEBELER_DELETION_GUARD_PERCENT": "101"}, "TRAEBELER_DELETION_GUARD_PERCENT has to be between 0 and 100 but is 101"},
+		{"liveness timeout leq zero", map[string]string{"TRAEBELER_LIVENESS_TIMEOUT": "0"}, "TRAEBELER_LIVENESS_TIMEOUT has to be at least 1 but is 0"},
+		{"negative shutdown grace period", map[string]string{"TRAEBELER_SHUTDOWN_GRACE_PERIOD": "-1"}, "TRAEBELER_SHUTDOWN_GRACE_PERIOD has to be at least 0 but is -1"},
+	}
+
+	for _, tt := range configTests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer test.ClearEnvs(test.SetEnvs(tt.vars))
+			_, err := readConfig()
+			assert.EqualError(t, err, "invalid worker config: "+tt.expected)
+		})
+	}
+}
+
+func TestReadConfig_whenMultipleOptionsAreOutOfRange_shouldNameAll(t *testing.T) {
+	defer test.ClearEnvs(test.SetEnvs(map[string]string{"TRAEBELER_ADD_AFTER_POLLS": "0", "TRAEBELER_CYCLE_TIMEOUT": "0"}))
+	_, err := readConfig()
+	assert.EqualError(t, err, "invalid worker config: TRAEBELER_ADD_AFTER_POLLS has to be at least 1 but is 0; TRAEBELER_CYCLE_TIMEOUT has to be at least 1 but is 0")
+}
+
+func TestDiff_shouldIdentifyDomainsByNameAndReAddChangedDescriptors(t *testing.T) {
+	previous := state{
+		domains: []domain.Domain{{Name: "foo.bar"}, {Name: "wiki.foo.bar", EntryPoints: []string{"web-lan"}}, {Name: "old.foo.bar"}},
+		ipv4:    "93.184.216.34",
+	}
+	current := state{
+		domains: []domain.Domain{{Name: "new.foo.bar"}, {Name: "wiki.foo.bar", EntryPoints: []string{"web-public"}}, {Name: "foo.bar"}},
+		ipv4:    "93.184.216.34",
+	}
+	changes := diff(previous, current)
+	assert.Equal(t, []domain.Domain{{Name: "new.foo.bar"}, {Name: "wiki.foo.bar", EntryPoints: []string{"web-public"}}}, changes.Added)
+	assert.Equal(t, []domain.Domain{{Name: "old.foo.bar"}}, changes.Removed)
+	assert.Equal(t, []domain.Domain{{Name: "foo.bar"}}, changes.Unchanged)
+	assert.Equal(t, current.domains, changes.Domains)
+	assert.False(t, changes.IPChanged)
+
+	current.ipv6 = "2606:2800:220:1:248:1893:25c8:1946"
+	assert.True(t, diff(previous, current).IPChanged, "a newly detected address should be a change")
+}
+
 type mockClock struct {
+	once       sync.Once
 	tickerChan chan time.Time
 }
 
 func (tc *mockClock) Ticker() <-chan time.Time {
-	if tc.tickerChan == nil {
-		tc.tickerChan = make(chan time.Time)
-	}
+	tc.once.Do(func() { tc.tickerChan = make(chan time.Time) })
 	return tc.tickerChan
 }
 
 func (tc *mockClock) Trigger() {
-	if tc.tickerChan == nil {
-		tc.tickerChan = make(chan time.Time)
-	}
+	tc.once.Do(func() { tc.tickerChan = make(chan time.Time) })
 	tc.tickerChan <- time.Now()
 }
 
+func TestProcessDomains_whenProviderExceedsTimeout_shouldAbortQuery(t *testing.T) {
+	w := worker{provider: blockingProvider{}, timeout: time.Millisecond * 10}
+	assert.Equal(t, context.DeadlineExceeded, w.processDomains(context.Background()))
+	assert.Equal(t, 1, w.providerFailures)
+}
+
 type staticProvider []string
 
-func (sp staticProvider) GetDomains() []string {
-	return sp
+func (sp staticProvider) GetDomains(_ context.Context) ([]domain.Domain, error) {
+	return toDomains(sp...), nil
+}
+
+// toDomains converts domain names to domains which are not served on any specific entrypoint.
+func toDomains(names ...string) []domain.Domain {
+	var domains []domain.Domain
+	for _, name := range names {
+		domains = append(domains, domain.Domain{Name: name})
+	}
+	return domains
+}
+
+// blockingProvider blocks until its context is done.
+type blockingProvider struct{}
+
+func (blockingProvider) GetDomains(ctx context.Context) ([]domain.Domain, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+type failingProvider struct {
+	err error
+}
+
+func (fp *failingProvider) GetDomains(_ context.Context) ([]domain.Domain, error) {
+	if fp.err != nil {
+		return nil, fp.err
+	}
+	return toDomains("lospolloshermanos.com"), nil
 }
 
 type assertingProcessor struct {
@@ -82,9 +254,10 @@ type assertingProcessor struct {
 	called      chan bool
 }
 
-func (ttp *assertingProcessor) Process(domains []string) {
+func (ttp *assertingProcessor) Process(_ context.Context, domains []domain.Domain) error {
 	assert.Len(ttp.t, domains, ttp.expectedLen, "expected domain slice with certain length")
 	ttp.called <- true
+	return nil
 }
 
 func (ttp *assertingProcessor) ID() string {